@@ -2,7 +2,10 @@ package main
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -10,10 +13,13 @@ import (
 
 	"github.com/tinfoilsh/tinfoil-go/verifier/attestation"
 	"github.com/tinfoilsh/tinfoil-go/verifier/client"
-	"github.com/tinfoilsh/tinfoil-go/verifier/github"
 	"github.com/tinfoilsh/tinfoil-go/verifier/sigstore"
 )
 
+// releaseTag and releaseDigest back the --tag and --digest flags shared by
+// the commands that verify an enclave.
+var releaseTag, releaseDigest string
+
 func init() {
 	rootCmd.AddCommand(attestationCmd)
 }
@@ -24,131 +30,331 @@ var attestationCmd = &cobra.Command{
 	Short:   "Attestation commands",
 }
 
-func tlsConnection(enclaveHost string) (*tls.ConnectionState, error) {
-	conn, err := tls.Dial("tcp", enclaveHost, &tls.Config{})
-	if err != nil {
-		return nil, fmt.Errorf("dialing enclave: %v", err)
-	}
-	cs := conn.ConnectionState()
-	return &cs, nil
-}
-
 type auditRecord struct {
 	Timestamp string `json:"timestamp"`
 
 	Enclave string `json:"enclave"`
 	Repo    string `json:"repo,omitempty"`
+	Tag     string `json:"tag,omitempty"`
 	Digest  string `json:"digest,omitempty"`
 
+	BundleDigest string       `json:"bundle_digest,omitempty"` // sha256 of the Sigstore bundle verified against
+	Policy       *policyMatch `json:"policy,omitempty"`        // policy entry the enclave matched, with --policy
+	Rekor        *rekorEntry  `json:"rekor,omitempty"`         // transparency log entry of the bundle, with --rekor
+
+	TrustRoot *trustRootInfo `json:"trust_root,omitempty"` // Sigstore trust root the bundle was verified with
+
+	Platform *platformReport `json:"platform,omitempty"` // TCB and guest policy details from the hardware report
+	Mode     *enclaveMode    `json:"mode,omitempty"`     // debug, staging and confidential computing mode
+
 	Measurements struct {
 		Sigstore attestation.Measurement  `json:"sigstore,omitempty"` // Measurement from sigstore bundle
 		Enclave  *attestation.Measurement `json:"enclave,omitempty"`  // Measurement from enclave attestation over HTTP
-		Cert     string                   `json:"cert,omitempty"`     // Measurement from enclave attestation in certificate
+		Cert     *attestation.Measurement `json:"cert,omitempty"`     // Measurement from enclave attestation in certificate
 	} `json:"measurements"`
 
 	Keys struct {
-		Enclave    string `json:"enclave,omitempty"`    // Public key from enclave attestation over HTTP
-		Connection string `json:"connection,omitempty"` // Public key from connection
-		Cert       string `json:"cert,omitempty"`       // Public key from dcode attestation in certificate
+		Enclave    string `json:"enclave,omitempty"`     // Public key from enclave attestation over HTTP
+		Connection string `json:"connection,omitempty"`  // Public key from connection
+		Cert       string `json:"cert,omitempty"`        // Public key from dcode attestation in certificate
+		HPKE       string `json:"hpke,omitempty"`        // HPKE public key from enclave attestation
+		HPKEServed string `json:"hpke_served,omitempty"` // HPKE public key served at /.well-known/hpke-keys
 	} `json:"keys"`
 
 	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
 	Error  string `json:"error,omitempty"`
 }
 
-func verifyAttestation(l *log.Logger) (*auditRecord, error) {
-	if enclaveHost == "" {
-		routerClient, err := client.NewDefaultClient()
-		if err != nil {
-			return nil, fmt.Errorf("getting router: %v", err)
+func newAuditRecord(enclave string) *auditRecord {
+	return &auditRecord{
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Enclave:   enclave,
+	}
+}
+
+// failedRecord describes a verification of enclave that stopped with err
+// before the enclave could be checked.
+func failedRecord(enclave, repo string, err error) *auditRecord {
+	rec := newAuditRecord(enclave)
+	rec.Repo = repo
+	rec.Status = statusForReason(reasonOf(err))
+	rec.Reason = reasonOf(err)
+	rec.Error = err.Error()
+	return rec
+}
+
+// fail records a failed check. The first failure determines the record's
+// status and reason.
+func (r *auditRecord) fail(reason, msg string) {
+	if r.Reason != "" {
+		return
+	}
+	r.Status = statusForReason(reason)
+	r.Reason = reason
+	r.Error = msg
+}
+
+// err returns the failure recorded in r as a *verificationError, or nil if
+// the verification passed. A record in statusError without a reason, such as
+// one built by failedRecord from an invalid option, yields a plain error.
+func (r *auditRecord) err() error {
+	if r.Reason == "" {
+		if r.Status == statusError {
+			return errors.New(r.Error)
 		}
-		enclaveHost = routerClient.Enclave()
-		l.Printf("Using auto selected router: %s", enclaveHost)
+		return nil
 	}
+	return &verificationError{Reason: r.Reason, Err: errors.New(r.Error)}
+}
 
-	var auditRec auditRecord
-	auditRec.Timestamp = time.Now().UTC().Format(time.RFC3339)
-	auditRec.Enclave = enclaveHost
+// verifyOptions selects the enclave to verify and the release it is
+// expected to run.
+type verifyOptions struct {
+	Host string
+	Repo string
 
-	var codeMeasurements *attestation.Measurement
-	if repo != "" {
-		l.Printf("Fetching latest release for %s", repo)
-		digest, err := github.FetchLatestDigest(repo)
+	// Tag and Digest pin the Sigstore bundle to compare against. When both
+	// are empty the latest release of Repo is used.
+	Tag    string
+	Digest string
+
+	// Policy is the path of a policy file to verify against instead of a
+	// single release.
+	Policy string
+
+	// Deployment is the controlplane record of the enclave when the caller
+	// already has it; otherwise it is looked up by Host.
+	Deployment *deploymentFlags
+}
+
+// currentVerifyOptions builds verifyOptions from the command-line flags.
+func currentVerifyOptions() verifyOptions {
+	return verifyOptions{
+		Host:   enclaveHost,
+		Repo:   repo,
+		Tag:    releaseTag,
+		Digest: releaseDigest,
+		Policy: policyPath,
+	}
+}
+
+// customVerification reports whether verification options the tinfoil-go
+// client does not support are in effect: --tag, --digest, --policy,
+// --min-tcb, --no-debug, a trust root other than the default, or a GitHub
+// instance other than github.com.
+func customVerification() bool {
+	return releaseTag != "" || releaseDigest != "" || policyPath != "" ||
+		len(platformRequirements.MinTCB) > 0 || platformRequirements.NoDebug ||
+		trustRootSource() != trustRootSourceDefault ||
+		currentGitHub().APIURL != defaultGitHubAPIURL
+}
+
+// evidence is the raw material a verification is based on: the enclave's
+// attestation document and TLS certificate chain, plus the Sigstore bundle
+// and trust root for the expected release. It is either fetched live by
+// collectEvidence or loaded from disk by loadEvidence.
+type evidence struct {
+	Host   string
+	Repo   string
+	Tag    string
+	Digest string
+
+	Bundle    []byte
+	TrustRoot []byte
+	// TrustRootSource says where TrustRoot came from (see trustRootSource).
+	TrustRootSource string
+	Attestation     *attestation.Document
+	PeerCerts       []*x509.Certificate
+	HPKEKeys        []byte // nil if the enclave does not serve HPKE keys
+
+	// RekorConsistency links the bundle's inclusion proof to the checkpoint
+	// given with --rekor-checkpoint. It is saved with the evidence so an
+	// offline replay can check a checkpoint of a different tree size.
+	RekorConsistency *consistencyProof
+
+	// CheckedByPolicy is set when the code measurement is checked against
+	// a policy after verifyEvidence, which then has no repo of its own.
+	CheckedByPolicy bool
+
+	// Deployment is the controlplane record of the container serving Host,
+	// if an API key is configured and one matches. It is only fetched live.
+	Deployment *deploymentFlags
+
+	// FetchedAt records when each piece was retrieved, keyed by its
+	// evidence file name.
+	FetchedAt map[string]time.Time
+}
+
+// verifyAttestation verifies the enclave selected by opts. The returned
+// record describes the outcome even when verification fails; the error is
+// a *verificationError for anything but invalid options.
+func verifyAttestation(l *log.Logger, opts verifyOptions) (*auditRecord, error) {
+	if opts.Policy != "" {
+		return verifyWithPolicy(l, opts)
+	}
+	ev, err := collectEvidence(l, opts)
+	if err != nil {
+		return failedRecord(opts.Host, opts.Repo, err), err
+	}
+	return verifyEvidence(l, ev)
+}
+
+// collectEvidence fetches everything verifyEvidence needs from the network.
+func collectEvidence(l *log.Logger, opts verifyOptions) (*evidence, error) {
+	if (opts.Tag != "" || opts.Digest != "") && opts.Repo == "" {
+		return nil, fmt.Errorf("--tag and --digest require --repo")
+	}
+
+	ev := &evidence{Host: opts.Host, Repo: opts.Repo, FetchedAt: map[string]time.Time{}}
+	if ev.Host == "" {
+		routerClient, err := client.NewDefaultClient()
 		if err != nil {
-			return nil, fmt.Errorf("fetching latest release: %v", err)
+			return nil, verifyErrorf(reasonFetchError, "getting router: %v", err)
 		}
-		auditRec.Repo = repo
-		auditRec.Digest = digest
+		ev.Host = routerClient.Enclave()
+		l.Printf("Using auto selected router: %s", ev.Host)
+	}
+	ev.Deployment = opts.Deployment
+	if ev.Deployment == nil {
+		ev.Deployment = lookupDeployment(l, ev.Host)
+	}
 
-		l.Printf("Fetching sigstore bundle from %s for digest %s", repo, digest)
-		bundleBytes, err := github.FetchAttestationBundle(repo, digest)
+	if ev.Repo != "" {
+		tag, digest, err := resolveRelease(l, ev.Repo, opts.Tag, opts.Digest)
 		if err != nil {
-			return nil, fmt.Errorf("fetching attestation bundle: %v", err)
+			return nil, err
 		}
+		ev.Tag = tag
+		ev.Digest = digest
 
-		l.Println("Fetching trust root")
-		trustRootJSON, err := sigstore.FetchTrustRoot()
+		ev.Bundle, ev.TrustRoot, err = fetchSigstoreMaterial(l, ev.Repo, ev.Digest)
 		if err != nil {
-			return nil, fmt.Errorf("fetching trust root: %v", err)
+			return nil, err
 		}
+		ev.TrustRootSource = trustRootSource()
+		ev.FetchedAt[evidenceBundleFile] = time.Now().UTC()
+		ev.FetchedAt[evidenceTrustRootFile] = time.Now().UTC()
 
-		l.Println("Verifying code measurements")
-		codeMeasurements, err = sigstore.VerifyAttestation(trustRootJSON, bundleBytes, repo, digest)
-		if err != nil {
-			return nil, fmt.Errorf("sigstore verify: %v", err)
+		if rekorOptions.Checkpoint != "" {
+			if ev.RekorConsistency, err = fetchRekorConsistency(l, ev.Bundle, ev.TrustRoot); err != nil {
+				return nil, err
+			}
+			if ev.RekorConsistency != nil {
+				ev.FetchedAt[evidenceRekorConsistencyFile] = time.Now().UTC()
+			}
 		}
-		auditRec.Measurements.Sigstore = *codeMeasurements
-	} else {
-		l.Warn("No repo specified, skipping code measurements")
-		auditRec.Status = "enclave_only"
 	}
 
-	l.Printf("Fetching attestation doc from %s", enclaveHost)
-	remoteAttestation, err := attestation.Fetch(enclaveHost)
+	l.Printf("Fetching attestation doc from %s", ev.Host)
+	doc, err := fetchAttestationDocument(ev.Host)
+	if err != nil {
+		return nil, verifyErrorf(reasonFetchError, "fetching attestation document: %v", err)
+	}
+	ev.Attestation = doc
+	ev.FetchedAt[evidenceAttestationFile] = time.Now().UTC()
+
+	// Get remote certificate chain
+	cs, err := tlsConnection(enclaveAddr(ev.Host))
 	if err != nil {
-		return nil, fmt.Errorf("fetching attestation document: %v", err)
+		return nil, verifyErrorf(reasonFetchError, "fetching remote public key fingerprint: %v", err)
+	}
+	ev.PeerCerts = cs.PeerCertificates
+	ev.FetchedAt[evidenceCertsFile] = time.Now().UTC()
+
+	l.Printf("Fetching HPKE keys from %s", ev.Host)
+	connFP, err := attestation.ConnectionCertFP(*cs)
+	if err != nil {
+		return nil, verifyErrorf(reasonFetchError, "fetching remote public key fingerprint: %v", err)
+	}
+	ev.HPKEKeys, err = fetchHPKEKeys(ev.Host, connFP)
+	if err != nil {
+		return nil, verifyErrorf(reasonFetchError, "fetching HPKE keys: %v", err)
+	}
+	if ev.HPKEKeys != nil {
+		ev.FetchedAt[evidenceHPKEKeysFile] = time.Now().UTC()
+	}
+
+	return ev, nil
+}
+
+// verifyEvidence runs the verification checks against ev without touching
+// the network.
+func verifyEvidence(l *log.Logger, ev *evidence) (*auditRecord, error) {
+	auditRec := newAuditRecord(ev.Host)
+
+	var codeMeasurements *attestation.Measurement
+	if ev.Repo != "" {
+		auditRec.Repo = ev.Repo
+		auditRec.Tag = ev.Tag
+		auditRec.Digest = ev.Digest
+		auditRec.BundleDigest = sha256Hex(ev.Bundle)
+		auditRec.TrustRoot = &trustRootInfo{Source: ev.TrustRootSource, SHA256: sha256Hex(ev.TrustRoot)}
+		measurement, err := verifyCodeMeasurement(l, ev.TrustRoot, ev.Bundle, ev.Repo, ev.Digest)
+		if err != nil {
+			auditRec.fail(reasonOf(err), err.Error())
+			return auditRec, err
+		}
+		codeMeasurements = measurement
+		auditRec.Measurements.Sigstore = *measurement
+
+		if rekorEnabled() {
+			entry, err := verifyRekorEntry(l, ev)
+			if err != nil {
+				auditRec.fail(reasonSigstoreError, err.Error())
+				return auditRec, auditRec.err()
+			}
+			auditRec.Rekor = entry
+		}
+	} else if !ev.CheckedByPolicy {
+		l.Warn("No repo specified, skipping code measurements")
 	}
 
 	l.Println("Verifying enclave measurements")
-	verification, err := remoteAttestation.Verify()
+	verification, err := ev.Attestation.Verify()
 	if err != nil {
-		return nil, fmt.Errorf("verifying attestation document: %v", err)
+		auditRec.fail(reasonAttestationInvalid, fmt.Sprintf("verifying attestation document: %v", err))
+		return auditRec, auditRec.err()
 	}
 	auditRec.Measurements.Enclave = verification.Measurement
 	auditRec.Keys.Enclave = verification.TLSPublicKeyFP
 	l.Printf("Public key fingerprint: %s", verification.TLSPublicKeyFP)
+	auditRec.Keys.HPKE = verification.HPKEPublicKey
+	if platform, err := parsePlatformReport(ev.Attestation); err != nil {
+		l.Debugf("Platform details unavailable: %v", err)
+	} else {
+		auditRec.Platform = platform
+	}
+	auditRec.Mode = detectEnclaveMode(ev.Attestation.Format, auditRec.Platform, ev.Deployment)
 	if verification.HPKEPublicKey != "" {
 		l.Printf("HPKE public key: %s", verification.HPKEPublicKey)
 	}
 
-	// Get remote pubkey fingerprint
-	cs, err := tlsConnection(enclaveHost + ":443")
-	if err != nil {
-		return nil, fmt.Errorf("fetching remote public key fingerprint: %v", err)
+	if len(ev.PeerCerts) == 0 {
+		auditRec.fail(reasonFetchError, "fetching remote public key fingerprint: no peer certificate")
+		return auditRec, auditRec.err()
 	}
-	pubkeyFP, err := attestation.ConnectionCertFP(*cs)
+	pubkeyFP, err := attestation.ConnectionCertFP(tls.ConnectionState{PeerCertificates: ev.PeerCerts})
 	if err != nil {
-		return nil, fmt.Errorf("fetching remote public key fingerprint: %v", err)
+		auditRec.fail(reasonFetchError, fmt.Sprintf("fetching remote public key fingerprint: %v", err))
+		return auditRec, auditRec.err()
 	}
 	auditRec.Keys.Connection = pubkeyFP
 	l.Debugf("Remote public key fingerprint: %s", pubkeyFP)
 
-	l.Debugf("Certificate SANs: %v", cs.PeerCertificates[0].DNSNames)
+	l.Debugf("Certificate SANs: %v", ev.PeerCerts[0].DNSNames)
 
 	// Compare remote public key fingerprint with attestation public key
 	if pubkeyFP != verification.TLSPublicKeyFP {
-		auditRec.Status = "FAILED"
-		auditRec.Error = "Remote public key fingerprint does not match attestation public key"
+		auditRec.fail(reasonKeyMismatch, "Remote public key fingerprint does not match attestation public key")
 		log.Printf("Remote public key fingerprint does not match attestation public key")
 	}
 
-	if repo != "" && codeMeasurements != nil && verification.Measurement != nil {
+	if ev.Repo != "" && codeMeasurements != nil && verification.Measurement != nil {
 		if err := codeMeasurements.Equals(verification.Measurement); err != nil {
-			auditRec.Status = "fail"
-			auditRec.Error = fmt.Sprintf("PCR register mismatch: %v", err)
+			auditRec.fail(reasonMeasurementMismatch, fmt.Sprintf("PCR register mismatch: %v", err))
 			log.Printf("PCR register mismatch. Verification failed: %v", err)
-			log.Printf("Code: %+v", codeMeasurements)
-			log.Printf("Enclave: %+v", verification.Measurement)
+			log.Printf("Differing registers: %s", strings.Join(differingRegisters(codeMeasurements, verification.Measurement), ", "))
 		} else {
 			l.Println("Measurements match")
 		}
@@ -156,9 +362,136 @@ func verifyAttestation(l *log.Logger) (*auditRecord, error) {
 		l.Printf("Enclave measurement: %+v", verification.Measurement)
 	}
 
+	// Clients using body encryption trust the served key, so it must be the
+	// one bound to the attestation.
+	if verification.HPKEPublicKey != "" {
+		if ev.HPKEKeys == nil {
+			if allowNoHPKE {
+				l.Warn("Enclave does not serve HPKE keys, skipping HPKE key check")
+			} else {
+				auditRec.fail(reasonKeyMismatch, "enclave attests an HPKE key but does not serve one at "+hpkeKeysPath+"; pass --allow-no-hpke to accept it")
+				log.Printf("Enclave does not serve its attested HPKE key")
+			}
+		} else if served, err := parseHPKEKeys(ev.HPKEKeys); err != nil {
+			auditRec.fail(reasonKeyMismatch, fmt.Sprintf("parsing served HPKE keys: %v", err))
+		} else {
+			auditRec.Keys.HPKEServed, err = checkHPKEKey(verification.HPKEPublicKey, served)
+			if err != nil {
+				auditRec.fail(reasonKeyMismatch, err.Error())
+				log.Printf("HPKE key mismatch: %v", err)
+			} else {
+				l.Println("HPKE key matches attestation")
+			}
+		}
+	}
+
+	if err := checkPlatformFlags(auditRec.Platform); err != nil {
+		auditRec.fail(reasonPolicyViolation, err.Error())
+	}
+	if err := checkEnclaveMode(auditRec.Mode); err != nil {
+		auditRec.fail(reasonPolicyViolation, err.Error())
+		log.Printf("Enclave mode not allowed: %v", err)
+	}
+
 	if auditRec.Status == "" {
-		auditRec.Status = "ok"
+		auditRec.Status = statusOK
+		if ev.Repo == "" {
+			auditRec.Status = statusEnclaveOnly
+		}
+	}
+
+	return auditRec, auditRec.err()
+}
+
+// resolveRelease picks the release digest to verify against. A pinned digest
+// is used as-is; a pinned tag is resolved through GitHub and, if a digest was
+// pinned too, must agree with it. With neither, the latest release is used.
+func resolveRelease(l *log.Logger, repo, tag, digest string) (string, string, error) {
+	if digest != "" {
+		d, err := normalizeDigest(digest)
+		if err != nil {
+			return "", "", err
+		}
+		digest = d
+		if tag == "" {
+			l.Printf("Using pinned digest %s for %s", digest, repo)
+			return "", digest, nil
+		}
+	}
+
+	if tag != "" {
+		l.Printf("Fetching release %s for %s", tag, repo)
+	} else {
+		l.Printf("Fetching latest release for %s", repo)
+	}
+	resolvedTag, resolvedDigest, err := fetchRelease(repo, tag)
+	if err != nil {
+		return "", "", verifyErrorf(reasonFetchError, "fetching release: %v", err)
+	}
+	if digest != "" && resolvedDigest != digest {
+		return "", "", fmt.Errorf("release %s of %s has digest %s, not the pinned %s", resolvedTag, repo, resolvedDigest, digest)
+	}
+	return resolvedTag, resolvedDigest, nil
+}
+
+// fetchCodeMeasurement resolves the release of repo selected by tag and
+// digest and returns the measurement attested by its Sigstore bundle.
+func fetchCodeMeasurement(l *log.Logger, repo, tag, digest string) (string, string, *attestation.Measurement, error) {
+	tag, digest, err := resolveRelease(l, repo, tag, digest)
+	if err != nil {
+		return "", "", nil, err
+	}
+	bundleBytes, trustRootJSON, err := fetchSigstoreMaterial(l, repo, digest)
+	if err != nil {
+		return "", "", nil, err
+	}
+	codeMeasurements, err := verifyCodeMeasurement(l, trustRootJSON, bundleBytes, repo, digest)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return tag, digest, codeMeasurements, nil
+}
+
+// fetchSigstoreMaterial downloads the Sigstore bundle for digest and the
+// trust root needed to verify it.
+func fetchSigstoreMaterial(l *log.Logger, repo, digest string) ([]byte, []byte, error) {
+	l.Printf("Fetching sigstore bundle from %s for digest %s", repo, digest)
+	bundleBytes, err := fetchAttestationBundle(repo, digest)
+	if err != nil {
+		return nil, nil, verifyErrorf(reasonFetchError, "fetching attestation bundle: %v", err)
+	}
+
+	l.Printf("Fetching trust root from %s", trustRootSource())
+	trustRootJSON, err := fetchTrustRoot()
+	if err != nil {
+		return nil, nil, verifyErrorf(reasonFetchError, "fetching trust root: %v", err)
 	}
+	return bundleBytes, trustRootJSON, nil
+}
 
-	return &auditRec, nil
+func verifyCodeMeasurement(l *log.Logger, trustRootJSON, bundleBytes []byte, repo, digest string) (*attestation.Measurement, error) {
+	l.Println("Verifying code measurements")
+	codeMeasurements, err := sigstore.VerifyAttestation(trustRootJSON, bundleBytes, repo, digest)
+	if err != nil {
+		return nil, verifyErrorf(reasonSigstoreError, "sigstore verify: %v", err)
+	}
+	return codeMeasurements, nil
+}
+
+// sameMeasurement reports whether a and b have the same type and identical
+// registers. Unlike Measurement.Equals it does not treat measurements from
+// different platforms as compatible, which is what change detection wants.
+func sameMeasurement(a, b *attestation.Measurement) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Type != b.Type || len(a.Registers) != len(b.Registers) {
+		return false
+	}
+	for i := range a.Registers {
+		if a.Registers[i] != b.Registers[i] {
+			return false
+		}
+	}
+	return true
 }
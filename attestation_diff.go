@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/tinfoilsh/tinfoil-go/verifier/attestation"
+)
+
+func init() {
+	attestationCmd.AddCommand(attestationDiffCmd)
+	attestationDiffCmd.Flags().BoolVarP(&jsonOutput, "json", "j", false, "Output in JSON format")
+}
+
+// recordField is one comparable value of an auditRecord, named by its JSON
+// path.
+type recordField struct {
+	Name  string
+	Value string
+}
+
+// fieldChange is one difference between two records. An empty side means
+// the field is not set in that record.
+type fieldChange struct {
+	Field string `json:"field"`
+	A     string `json:"a"`
+	B     string `json:"b"`
+}
+
+type recordDiff struct {
+	A       string        `json:"a"`
+	B       string        `json:"b"`
+	Changes []fieldChange `json:"changes"`
+}
+
+var attestationDiffCmd = &cobra.Command{
+	Use:   "diff <a> <b>",
+	Short: "Compare two verification records or evidence captures",
+	Long: `Compare two verification records (the JSON written by ` + "`attestation verify -j`" + `)
+or saved evidence (a directory or .tar.gz from ` + "`attestation fetch`" + `) field by
+field: repo, release digest, measurement registers, and TLS and HPKE keys.
+Evidence is verified offline first to extract those values.`,
+	Args:         cobra.ExactArgs(2),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := log.New()
+		logger.SetOutput(io.Discard)
+		if verbose || trace {
+			logger.SetOutput(os.Stderr)
+			logger.SetLevel(log.DebugLevel)
+		}
+
+		a, err := loadComparableRecord(logger, args[0])
+		if err != nil {
+			return err
+		}
+		b, err := loadComparableRecord(logger, args[1])
+		if err != nil {
+			return err
+		}
+
+		diff := recordDiff{A: args[0], B: args[1], Changes: diffRecords(a, b)}
+		if jsonOutput {
+			return printJSON(diff)
+		}
+
+		fmt.Printf("--- %s (%s)\n", args[0], a.Timestamp)
+		fmt.Printf("+++ %s (%s)\n", args[1], b.Timestamp)
+		if len(diff.Changes) == 0 {
+			fmt.Println("No differences")
+			return nil
+		}
+		for _, c := range diff.Changes {
+			fmt.Printf("%s:\n  - %s\n  + %s\n", c.Field, orNone(c.A), orNone(c.B))
+		}
+		return nil
+	},
+}
+
+// loadComparableRecord reads a verification record, or replays saved
+// evidence into one. A record signed with --sign-key is unwrapped from its
+// DSSE envelope without checking the signature; use `attestation report
+// verify` for that. VSA statements do not carry enough detail and are
+// rejected.
+func loadComparableRecord(l *log.Logger, path string) (*auditRecord, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() || isTarball(path) {
+		ev, err := loadEvidence(l, path, verifyOptions{})
+		if err != nil {
+			return nil, err
+		}
+		record, err := verifyEvidence(l, ev)
+		if record == nil {
+			return nil, err
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: verification failed: %v\n", path, err)
+		}
+		return record, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseComparableRecord(path, data)
+}
+
+// parseComparableRecord decodes a verification record, unwrapping it from a
+// DSSE envelope if needed.
+func parseComparableRecord(path string, data []byte) (*auditRecord, error) {
+	var probe struct {
+		PayloadType   string `json:"payloadType"`
+		Payload       string `json:"payload"`
+		PredicateType string `json:"predicateType"`
+		Enclave       string `json:"enclave"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	if probe.PayloadType != "" {
+		if probe.PayloadType != reportPayloadType {
+			return nil, fmt.Errorf("%s is a signed %s envelope, not a verification record", path, probe.PayloadType)
+		}
+		payload, err := base64.StdEncoding.DecodeString(probe.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("decoding %s payload: %v", path, err)
+		}
+		return parseComparableRecord(path, payload)
+	}
+	if probe.PredicateType != "" {
+		return nil, fmt.Errorf("%s is an in-toto statement (%s), not a verification record; compare the -j output instead", path, probe.PredicateType)
+	}
+	if probe.Enclave == "" {
+		return nil, fmt.Errorf("%s is not a verification record", path)
+	}
+
+	var record auditRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	return &record, nil
+}
+
+// diffRecords lists the fields that differ between a and b, in the order
+// they appear in a record.
+func diffRecords(a, b *auditRecord) []fieldChange {
+	fa, fb := flattenRecord(a), flattenRecord(b)
+	valuesB := make(map[string]string, len(fb))
+	for _, f := range fb {
+		valuesB[f.Name] = f.Value
+	}
+	seen := make(map[string]bool, len(fa))
+
+	changes := []fieldChange{}
+	for _, f := range fa {
+		seen[f.Name] = true
+		if f.Value != valuesB[f.Name] {
+			changes = append(changes, fieldChange{Field: f.Name, A: f.Value, B: valuesB[f.Name]})
+		}
+	}
+	for _, f := range fb {
+		if !seen[f.Name] && f.Value != "" {
+			changes = append(changes, fieldChange{Field: f.Name, B: f.Value})
+		}
+	}
+	return changes
+}
+
+// flattenRecord lists the fields of r that are worth comparing. Timestamps
+// always differ and are left out.
+func flattenRecord(r *auditRecord) []recordField {
+	fields := []recordField{
+		{"enclave", r.Enclave},
+		{"repo", r.Repo},
+		{"tag", r.Tag},
+		{"digest", r.Digest},
+		{"bundle_digest", r.BundleDigest},
+		{"status", r.Status},
+		{"reason", r.Reason},
+	}
+	if r.Rekor != nil {
+		fields = append(fields,
+			recordField{"rekor.log_index", strconv.FormatInt(r.Rekor.LogIndex, 10)},
+			recordField{"rekor.integrated_time", r.Rekor.IntegratedTime},
+		)
+	}
+	if r.Mode != nil {
+		fields = append(fields,
+			recordField{"mode.debug", strconv.FormatBool(r.Mode.Debug)},
+			recordField{"mode.staging", strconv.FormatBool(r.Mode.Staging)},
+			recordField{"mode.non_cc", strconv.FormatBool(r.Mode.NonCC)},
+		)
+	}
+	if r.Policy != nil {
+		fields = append(fields,
+			recordField{"policy.sha256", r.Policy.SHA256},
+			recordField{"policy.entry", r.Policy.Entry},
+		)
+	}
+	fields = append(fields, measurementFields("measurements.sigstore", &r.Measurements.Sigstore)...)
+	fields = append(fields, measurementFields("measurements.enclave", r.Measurements.Enclave)...)
+	fields = append(fields, measurementFields("measurements.cert", r.Measurements.Cert)...)
+	fields = append(fields,
+		recordField{"keys.enclave", r.Keys.Enclave},
+		recordField{"keys.connection", r.Keys.Connection},
+		recordField{"keys.cert", r.Keys.Cert},
+		recordField{"keys.hpke", r.Keys.HPKE},
+		recordField{"keys.hpke_served", r.Keys.HPKEServed},
+	)
+	return fields
+}
+
+func measurementFields(prefix string, m *attestation.Measurement) []recordField {
+	if m == nil {
+		return nil
+	}
+	fields := []recordField{{prefix + ".type", string(m.Type)}}
+	for i, reg := range m.Registers {
+		fields = append(fields, recordField{fmt.Sprintf("%s.registers[%d]", prefix, i), reg})
+	}
+	return fields
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "(none)"
+	}
+	return s
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tinfoilsh/tinfoil-go/verifier/attestation"
+)
+
+func TestDiffRecords(t *testing.T) {
+	a := newAuditRecord("enclave.example.com")
+	a.Repo = "tinfoilsh/example"
+	a.Digest = "d1"
+	a.Status = statusOK
+	a.Keys.Enclave = "k1"
+	a.Measurements.Enclave = &attestation.Measurement{Type: "t", Registers: []string{"r0", "r1"}}
+
+	b := *a
+	b.Timestamp = "later"
+	assert.Empty(t, diffRecords(a, &b))
+
+	b.Digest = "d2"
+	b.Keys.Enclave = "k2"
+	b.Keys.HPKE = "h"
+	b.Measurements.Enclave = &attestation.Measurement{Type: "t", Registers: []string{"r0", "r1b", "r2"}}
+	assert.Equal(t, []fieldChange{
+		{Field: "digest", A: "d1", B: "d2"},
+		{Field: "measurements.enclave.registers[1]", A: "r1", B: "r1b"},
+		{Field: "keys.enclave", A: "k1", B: "k2"},
+		{Field: "keys.hpke", A: "", B: "h"},
+		{Field: "measurements.enclave.registers[2]", A: "", B: "r2"},
+	}, diffRecords(a, &b))
+}
+
+func TestParseComparableRecord(t *testing.T) {
+	record := newAuditRecord("enclave.example.com")
+	record.Repo = "tinfoilsh/example"
+	record.Status = statusOK
+	payload, err := json.Marshal(record)
+	require.NoError(t, err)
+
+	got, err := parseComparableRecord("r.json", payload)
+	require.NoError(t, err)
+	assert.Equal(t, record, got)
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signed, err := json.Marshal(signEnvelope(reportPayloadType, payload, priv))
+	require.NoError(t, err)
+	got, err = parseComparableRecord("signed.json", signed)
+	require.NoError(t, err)
+	assert.Equal(t, record, got)
+
+	vsa, err := json.Marshal(inTotoStatement{Type: inTotoStatementType, PredicateType: vsaPredicateType})
+	require.NoError(t, err)
+	_, err = parseComparableRecord("vsa.json", vsa)
+	assert.ErrorContains(t, err, "in-toto statement")
+
+	signedVSA, err := json.Marshal(signEnvelope(inTotoPayloadType, vsa, priv))
+	require.NoError(t, err)
+	_, err = parseComparableRecord("signed-vsa.json", signedVSA)
+	assert.ErrorContains(t, err, "not a verification record")
+
+	_, err = parseComparableRecord("other.json", []byte(`{"foo":"bar"}`))
+	assert.ErrorContains(t, err, "not a verification record")
+}
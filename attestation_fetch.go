@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var fetchSavePath string
+
+func init() {
+	attestationCmd.AddCommand(attestationFetchCmd)
+	attestationFetchCmd.Flags().StringVar(&fetchSavePath, "save", "", "Directory or .tar.gz file to write the evidence to [required]")
+	attestationFetchCmd.Flags().StringVar(&releaseTag, "tag", "", "Capture the Sigstore bundle for this release tag instead of the latest release")
+	attestationFetchCmd.Flags().StringVar(&releaseDigest, "digest", "", "Capture the Sigstore bundle for this release digest instead of the latest release")
+	attestationFetchCmd.Flags().StringVar(&trustRootPath, "trust-root", "", trustRootUsage)
+	attestationFetchCmd.Flags().StringVar(&rekorOptions.Checkpoint, "rekor-checkpoint", "", fetchRekorCheckpointUsage)
+	_ = attestationFetchCmd.MarkFlagRequired("save")
+}
+
+var attestationFetchCmd = &cobra.Command{
+	Use:   "fetch",
+	Short: "Capture raw attestation evidence for later verification",
+	Long: `Fetch the enclave's attestation document and TLS certificate chain, plus
+the Sigstore bundle, digest and trust root for the expected release, and write
+them with a manifest of hashes and timestamps. Replay the capture with
+` + "`tinfoil attestation verify --offline <path>`" + `.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := log.New()
+		if verbose {
+			logger.SetLevel(log.DebugLevel)
+		} else if trace {
+			logger.SetLevel(log.TraceLevel)
+		}
+
+		capturedAt := time.Now()
+		ev, err := collectEvidence(logger, currentVerifyOptions())
+		if err != nil {
+			return err
+		}
+		manifest, err := saveEvidence(ev, fetchSavePath, capturedAt)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Saved evidence for %s to %s\n", manifest.Enclave, fetchSavePath)
+		for _, f := range manifest.Files {
+			fmt.Printf("  %-20s  sha256:%s\n", f.Name, f.SHA256)
+		}
+		return nil
+	},
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/tinfoilsh/tinfoil-go/verifier/attestation"
+)
+
+var (
+	historyLimit      int
+	historyContainers bool
+)
+
+func init() {
+	attestationCmd.AddCommand(attestationHistoryCmd)
+	attestationHistoryCmd.Flags().IntVar(&historyLimit, "limit", 10, "Number of recent releases to list")
+	attestationHistoryCmd.Flags().BoolVar(&historyContainers, "containers", false, "Mark the releases that your running containers of the repo match (requires an API key)")
+	attestationHistoryCmd.Flags().BoolVarP(&jsonOutput, "json", "j", false, "Output in JSON format")
+}
+
+// releaseHistoryEntry is one release of a repo with its Sigstore-verified
+// measurement. Error is set instead when the release could not be verified.
+type releaseHistoryEntry struct {
+	Tag         string                   `json:"tag"`
+	PublishedAt string                   `json:"published_at,omitempty"`
+	Digest      string                   `json:"digest,omitempty"`
+	SignedAt    string                   `json:"signed_at,omitempty"` // Rekor integrated time of the bundle
+	Measurement *attestation.Measurement `json:"measurement,omitempty"`
+	Containers  []string                 `json:"containers,omitempty"` // running containers attesting this measurement
+	Error       string                   `json:"error,omitempty"`
+}
+
+// historyContainer is a running container of the repo and the release its
+// attested measurement matches, if any.
+type historyContainer struct {
+	Container string `json:"container"`
+	Tag       string `json:"tag,omitempty"`     // tag the controlplane says it runs
+	Release   string `json:"release,omitempty"` // release whose measurement it attests
+	Error     string `json:"error,omitempty"`
+}
+
+type releaseHistory struct {
+	Repo       string                 `json:"repo"`
+	Releases   []*releaseHistoryEntry `json:"releases"`
+	Containers []historyContainer     `json:"containers,omitempty"`
+}
+
+var attestationHistoryCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List recent releases of a repo with their measurements",
+	Long: `List the most recent releases of --repo with their attestation digests,
+the measurements verified from their Sigstore bundles and the time each
+bundle was signed (its Rekor integration time).
+
+With --containers, the attestation of each of your running containers of the
+repo is fetched and the releases whose measurement they attest are marked.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if repo == "" {
+			return fmt.Errorf("--repo is required")
+		}
+		if historyLimit < 1 {
+			return fmt.Errorf("--limit must be at least 1")
+		}
+
+		logger := log.New()
+		logger.SetOutput(io.Discard)
+		if verbose || trace {
+			logger.SetOutput(os.Stderr)
+			logger.SetLevel(log.DebugLevel)
+		}
+
+		var containers []containerView
+		if historyContainers {
+			client, err := authedClient()
+			if err != nil {
+				return err
+			}
+			if _, err := client.do("GET", "/api/containers", nil, nil, &containers); err != nil {
+				return err
+			}
+		}
+
+		releases, err := listReleases(repo, historyLimit)
+		if err != nil {
+			return fmt.Errorf("listing releases of %s: %w", repo, err)
+		}
+		trustRoot, err := fetchTrustRoot()
+		if err != nil {
+			return fmt.Errorf("fetching trust root: %w", err)
+		}
+
+		history := &releaseHistory{Repo: repo, Releases: make([]*releaseHistoryEntry, 0, len(releases))}
+		for _, rel := range releases {
+			history.Releases = append(history.Releases, releaseHistoryOf(logger, trustRoot, repo, rel))
+		}
+		if historyContainers {
+			history.Containers = matchRunningContainers(logger, history.Releases, repoContainers(containers, repo), containerMeasurement)
+		}
+
+		if jsonOutput {
+			return printJSON(history)
+		}
+		renderReleaseHistory(os.Stdout, history)
+		return nil
+	},
+}
+
+// releaseHistoryOf resolves the digest of rel and verifies its Sigstore
+// bundle. Failures are recorded on the entry so one bad release does not
+// hide the others.
+func releaseHistoryOf(l *log.Logger, trustRoot []byte, repo string, rel githubRelease) *releaseHistoryEntry {
+	entry := &releaseHistoryEntry{Tag: rel.TagName, PublishedAt: rel.PublishedAt}
+	digest, err := digestOfRelease(repo, rel)
+	if err != nil {
+		entry.Error = err.Error()
+		return entry
+	}
+	entry.Digest = digest
+
+	bundle, err := fetchAttestationBundle(repo, digest)
+	if err != nil {
+		entry.Error = fmt.Sprintf("fetching bundle: %v", err)
+		return entry
+	}
+	if entry.Measurement, err = verifyCodeMeasurement(l, trustRoot, bundle, repo, digest); err != nil {
+		entry.Error = err.Error()
+		return entry
+	}
+	// The signing time is only reported for a bundle that verified.
+	if p, err := parseBundleRekorProof(bundle); err == nil {
+		entry.SignedAt = p.Entry.IntegratedTime
+	} else {
+		l.Debugf("%s: no Rekor entry: %v", rel.TagName, err)
+	}
+	return entry
+}
+
+// repoContainers returns the containers deployed from repo.
+func repoContainers(containers []containerView, repo string) []containerView {
+	var matched []containerView
+	for _, c := range containers {
+		if strings.EqualFold(c.Repo, repo) {
+			matched = append(matched, c)
+		}
+	}
+	return matched
+}
+
+// matchRunningContainers reads the measurement each container attests with
+// measure and marks the release it matches. Containers are matched against
+// the newest release first.
+func matchRunningContainers(l *log.Logger, releases []*releaseHistoryEntry, containers []containerView,
+	measure func(containerView) (*attestation.Measurement, error)) []historyContainer {
+	results := make([]historyContainer, 0, len(containers))
+	for _, c := range containers {
+		hc := historyContainer{Container: c.Name, Tag: c.CurrentTag}
+		measurement, err := measure(c)
+		if err != nil {
+			hc.Error = err.Error()
+			l.Debugf("%s: %v", c.Name, err)
+			results = append(results, hc)
+			continue
+		}
+		for _, r := range releases {
+			if r.Measurement != nil && r.Measurement.Equals(measurement) == nil {
+				hc.Release = r.Tag
+				r.Containers = append(r.Containers, c.Name)
+				break
+			}
+		}
+		results = append(results, hc)
+	}
+	return results
+}
+
+// containerMeasurement returns the measurement attested by a running
+// container, fetched from its public domain or else its internal one.
+func containerMeasurement(c containerView) (*attestation.Measurement, error) {
+	host := strings.TrimSpace(c.Domain)
+	if host == "" {
+		host = strings.TrimSpace(c.InternalDomain)
+	}
+	if host == "" {
+		return nil, fmt.Errorf("no domain (status=%s)", c.Status)
+	}
+	doc, err := fetchAttestationDocument(host)
+	if err != nil {
+		return nil, fmt.Errorf("fetching attestation from %s: %v", host, err)
+	}
+	verification, err := doc.Verify()
+	if err != nil {
+		return nil, fmt.Errorf("verifying attestation from %s: %v", host, err)
+	}
+	return verification.Measurement, nil
+}
+
+func renderReleaseHistory(w io.Writer, h *releaseHistory) {
+	if len(h.Releases) == 0 {
+		fmt.Fprintf(w, "No releases of %s.\n", h.Repo)
+		return
+	}
+	fmt.Fprintf(w, "%-12s  %-20s  %-16s  %-24s  %s\n", "TAG", "SIGNED", "DIGEST", "MEASUREMENT", "CONTAINERS")
+	for _, r := range h.Releases {
+		if r.Error != "" {
+			fmt.Fprintf(w, "%-12s  %-20s  %-16s  %-24s  %s\n", truncate(r.Tag, 12), orDash(r.SignedAt),
+				orDash(truncate(r.Digest, 16)), "-", "error: "+r.Error)
+			continue
+		}
+		fmt.Fprintf(w, "%-12s  %-20s  %-16s  %-24s  %s\n", truncate(r.Tag, 12), orDash(r.SignedAt),
+			truncate(r.Digest, 16), measurementSummary(r.Measurement), orDash(strings.Join(r.Containers, ", ")))
+	}
+
+	var unmatched []string
+	for _, c := range h.Containers {
+		switch {
+		case c.Error != "":
+			unmatched = append(unmatched, fmt.Sprintf("%s: %s", c.Container, c.Error))
+		case c.Release == "":
+			unmatched = append(unmatched, fmt.Sprintf("%s (tag %s) matches none of these releases", c.Container, orDash(c.Tag)))
+		}
+	}
+	if len(unmatched) > 0 {
+		fmt.Fprintln(w)
+		for _, u := range unmatched {
+			fmt.Fprintln(w, u)
+		}
+	}
+}
+
+// measurementSummary abbreviates a measurement to its first register.
+func measurementSummary(m *attestation.Measurement) string {
+	if m == nil || len(m.Registers) == 0 {
+		return "-"
+	}
+	return truncate(m.Registers[0], 24)
+}
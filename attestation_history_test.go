@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tinfoilsh/tinfoil-go/verifier/attestation"
+)
+
+func TestListReleasesAndDigests(t *testing.T) {
+	digest := strings.Repeat("ab", 32)
+	var gotQuery string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/repos/acme/app/releases":
+			gotQuery = r.URL.RawQuery
+			w.Write([]byte(`[
+				{"tag_name":"v3","body":"Digest: ` + "`" + strings.ToUpper(digest) + "`" + `","published_at":"2026-01-03T00:00:00Z"},
+				{"tag_name":"v2","body":"no digest here"},
+				{"tag_name":"v1","body":"EIF hash: ` + digest + `"}
+			]`))
+		case "/acme/app/releases/download/v2/tinfoil.hash":
+			w.Write([]byte("sha256:" + digest + "\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	useGitHub(t, githubSettings{APIURL: srv.URL + "/api/v3", URL: srv.URL})
+
+	releases, err := listReleases("acme/app", 2)
+	require.NoError(t, err)
+	assert.Equal(t, "per_page=2&page=1", gotQuery)
+	require.Len(t, releases, 2)
+	assert.Equal(t, "2026-01-03T00:00:00Z", releases[0].PublishedAt)
+
+	for _, rel := range releases {
+		got, err := digestOfRelease("acme/app", rel)
+		require.NoError(t, err, rel.TagName)
+		assert.Equal(t, digest, got, rel.TagName)
+	}
+
+	_, err = digestOfRelease("acme/app", githubRelease{TagName: "v0"})
+	assert.ErrorContains(t, err, "release v0 has no digest")
+}
+
+func TestListReleasesPaginates(t *testing.T) {
+	var pages []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pages = append(pages, r.URL.Query().Get("page"))
+		n := 100
+		if r.URL.Query().Get("page") == "2" {
+			n = 30
+		}
+		w.Write([]byte("[" + strings.TrimSuffix(strings.Repeat(`{"tag_name":"v"},`, n), ",") + "]"))
+	}))
+	defer srv.Close()
+	useGitHub(t, githubSettings{APIURL: srv.URL, URL: srv.URL})
+
+	releases, err := listReleases("acme/app", 150)
+	require.NoError(t, err)
+	assert.Len(t, releases, 130)
+	assert.Equal(t, []string{"1", "2"}, pages)
+}
+
+func TestMatchRunningContainers(t *testing.T) {
+	m := func(r string) *attestation.Measurement {
+		return &attestation.Measurement{Type: testSNPType, Registers: []string{r}}
+	}
+	releases := []*releaseHistoryEntry{
+		{Tag: "v2", Measurement: m("new")},
+		{Tag: "v1", Measurement: m("old")},
+		{Tag: "v0", Error: "fetching bundle: not found"},
+	}
+	containers := []containerView{
+		{Name: "app-1", CurrentTag: "v2"},
+		{Name: "app-2", CurrentTag: "v1"},
+		{Name: "app-3", CurrentTag: "v9"},
+		{Name: "app-4", CurrentTag: "v2"},
+	}
+	attested := map[string]*attestation.Measurement{"app-1": m("new"), "app-2": m("old"), "app-3": m("other")}
+	measure := func(c containerView) (*attestation.Measurement, error) {
+		if attested[c.Name] == nil {
+			return nil, errors.New("no domain (status=stopped)")
+		}
+		return attested[c.Name], nil
+	}
+
+	got := matchRunningContainers(discardLogger(), releases, containers, measure)
+	assert.Equal(t, []historyContainer{
+		{Container: "app-1", Tag: "v2", Release: "v2"},
+		{Container: "app-2", Tag: "v1", Release: "v1"},
+		{Container: "app-3", Tag: "v9"},
+		{Container: "app-4", Tag: "v2", Error: "no domain (status=stopped)"},
+	}, got)
+	assert.Equal(t, []string{"app-1"}, releases[0].Containers)
+	assert.Equal(t, []string{"app-2"}, releases[1].Containers)
+	assert.Empty(t, releases[2].Containers)
+}
+
+func TestRepoContainers(t *testing.T) {
+	containers := []containerView{
+		{Name: "a", Repo: "Acme/App"},
+		{Name: "b", Repo: "acme/other"},
+		{Name: "c", Repo: "acme/app"},
+	}
+	got := repoContainers(containers, "acme/app")
+	require.Len(t, got, 2)
+	assert.Equal(t, "a", got[0].Name)
+	assert.Equal(t, "c", got[1].Name)
+}
+
+func TestRenderReleaseHistory(t *testing.T) {
+	var buf bytes.Buffer
+	renderReleaseHistory(&buf, &releaseHistory{Repo: "acme/app"})
+	assert.Equal(t, "No releases of acme/app.\n", buf.String())
+
+	buf.Reset()
+	renderReleaseHistory(&buf, &releaseHistory{
+		Repo: "acme/app",
+		Releases: []*releaseHistoryEntry{
+			{
+				Tag:         "v2",
+				Digest:      strings.Repeat("ab", 32),
+				SignedAt:    "2026-01-02T00:00:00Z",
+				Measurement: &attestation.Measurement{Registers: []string{strings.Repeat("cd", 48)}},
+				Containers:  []string{"app-1", "app-2"},
+			},
+			{Tag: "v1", Error: "release v1 has no digest"},
+		},
+		Containers: []historyContainer{
+			{Container: "app-1", Tag: "v2", Release: "v2"},
+			{Container: "app-2", Tag: "v2", Release: "v2"},
+			{Container: "app-3", Tag: "v0"},
+			{Container: "app-4", Error: "no domain (status=starting)"},
+		},
+	})
+	out := buf.String()
+	assert.Contains(t, out, "2026-01-02T00:00:00Z")
+	assert.Contains(t, out, "app-1, app-2")
+	assert.Contains(t, out, "error: release v1 has no digest")
+	assert.Contains(t, out, "app-3 (tag v0) matches none of these releases")
+	assert.Contains(t, out, "app-4: no domain (status=starting)")
+	assert.NotContains(t, out, "app-1 (tag")
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/tinfoilsh/tinfoil-go/verifier/client"
+)
+
+// defaultRouterListURL lists the routers the tinfoil-go default client
+// picks from. It can be overridden with router_list_url in the config or
+// TINFOIL_ROUTERS_URL.
+const defaultRouterListURL = "https://atc.tinfoil.sh/routers"
+
+// verifyAllRouters backs the --all-routers flag.
+var verifyAllRouters bool
+
+// fetchRouters returns the hosts of every router in the pool, sorted.
+func fetchRouters() ([]string, error) {
+	cfg, _, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	url := cfg.RouterListURL
+	if url == "" {
+		url = defaultRouterListURL
+	}
+	resp, err := enclaveHTTP.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("reading router list: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	var hosts []string
+	if err := json.Unmarshal(body, &hosts); err != nil {
+		return nil, fmt.Errorf("decoding router list: %w", err)
+	}
+
+	seen := map[string]bool{}
+	routers := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		h = strings.TrimSpace(h)
+		if h != "" && !seen[h] {
+			seen[h] = true
+			routers = append(routers, h)
+		}
+	}
+	if len(routers) == 0 {
+		return nil, fmt.Errorf("router list at %s is empty", url)
+	}
+	sort.Strings(routers)
+	return routers, nil
+}
+
+// verifyRouterPool implements attestation verify --all-routers: it verifies
+// every router and prints a table, or with --json an array of reports in
+// the --format output format. Without --repo or --policy the routers are
+// compared with the router repo of the tinfoil-go default client, so that
+// the pool is not reported healthy on the hardware attestation alone.
+func verifyRouterPool(l *log.Logger, signKey ed25519.PrivateKey) error {
+	routers, err := fetchRouters()
+	if err != nil {
+		return verifyErrorf(reasonFetchError, "listing routers: %v", err)
+	}
+	opts := currentVerifyOptions()
+	if opts.Repo == "" && opts.Policy == "" {
+		router, err := client.NewDefaultClient()
+		if err != nil {
+			return verifyErrorf(reasonFetchError, "getting router repo: %v", err)
+		}
+		opts.Repo = router.Repo()
+		l.Printf("Comparing routers with the latest release of %s", opts.Repo)
+	}
+	l.Printf("Verifying %d routers", len(routers))
+	records := verifyRouters(l, routers, opts, verifyConcurrency)
+
+	reports := make([]json.RawMessage, 0, len(records))
+	for _, r := range records {
+		if auditLogPath != "" {
+			if err := appendAuditLog(auditLogPath, r); err != nil {
+				return err
+			}
+		}
+		report, err := formatReport(r, signKey)
+		if err != nil {
+			return fmt.Errorf("error marshaling JSON: %v", err)
+		}
+		reports = append(reports, report)
+	}
+	output, err := json.MarshalIndent(reports, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling JSON: %v", err)
+	}
+
+	if jsonOutput {
+		fmt.Println(string(output))
+	} else {
+		renderRouterVerifications(os.Stdout, records)
+	}
+	if jsonFile != "" {
+		if err := os.WriteFile(jsonFile, output, 0644); err != nil {
+			return fmt.Errorf("error writing JSON to file: %v", err)
+		}
+	}
+	return routerPoolError(records)
+}
+
+// verifyRouters verifies every router with opts, at most concurrency at a
+// time, and returns the records in the order of routers.
+func verifyRouters(l *log.Logger, routers []string, opts verifyOptions, concurrency int) []*auditRecord {
+	records := make([]*auditRecord, len(routers))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, host := range routers {
+		wg.Add(1)
+		go func(i int, opts verifyOptions) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			records[i] = recordWithError(verifyLive(l, opts))
+		}(i, withHost(opts, host))
+	}
+	wg.Wait()
+	return records
+}
+
+// recordWithError returns record with err recorded on it, so that an error
+// without a reason code, such as an unreadable known_enclaves file, is not
+// lost when only the record is kept.
+func recordWithError(record *auditRecord, err error) *auditRecord {
+	if err != nil && record.err() == nil {
+		record.fail(reasonFetchError, err.Error())
+	}
+	return record
+}
+
+func withHost(opts verifyOptions, host string) verifyOptions {
+	opts.Host = host
+	return opts
+}
+
+// routerPoolError summarizes failed router verifications. It wraps the
+// first failure so the exit code reflects its reason.
+func routerPoolError(records []*auditRecord) error {
+	var first error
+	failed := 0
+	for _, r := range records {
+		if err := r.err(); err != nil {
+			failed++
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	if failed == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d routers failed verification: %w", failed, len(records), first)
+}
+
+func renderRouterVerifications(w io.Writer, records []*auditRecord) {
+	fmt.Fprintf(w, "%-40s  %-10s  %-12s  %s\n", "ROUTER", "TAG", "STATUS", "DETAIL")
+	for _, r := range records {
+		detail := r.Reason
+		if r.Error != "" {
+			detail = r.Error
+		}
+		fmt.Fprintf(w, "%-40s  %-10s  %-12s  %s\n", truncate(r.Enclave, 40), truncate(orDash(r.Tag), 10), r.Status, detail)
+	}
+	enclaveOnly := 0
+	for _, r := range records {
+		if r.Status == statusEnclaveOnly {
+			enclaveOnly++
+		}
+	}
+	if enclaveOnly > 0 {
+		fmt.Fprintf(w, "\n%d of %d routers were not compared with a release; only their hardware attestation was checked.\n", enclaveOnly, len(records))
+	}
+}
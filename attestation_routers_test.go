@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchRouters(t *testing.T) {
+	body := `["router-b.tinfoil.sh", " router-a.tinfoil.sh ", "router-b.tinfoil.sh", ""]`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	t.Setenv(envConfigPath, filepath.Join(t.TempDir(), "config.json"))
+	t.Setenv(envRouterListURL, srv.URL)
+
+	routers, err := fetchRouters()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"router-a.tinfoil.sh", "router-b.tinfoil.sh"}, routers)
+
+	body = `[]`
+	_, err = fetchRouters()
+	assert.ErrorContains(t, err, "empty")
+}
+
+func TestFetchRoutersFromConfig(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`["router-a.tinfoil.sh"]`))
+	}))
+	defer srv.Close()
+	cfgPath := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(cfgPath, []byte(`{"router_list_url":"`+srv.URL+`"}`), 0o600))
+	t.Setenv(envConfigPath, cfgPath)
+	t.Setenv(envRouterListURL, "")
+
+	routers, err := fetchRouters()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"router-a.tinfoil.sh"}, routers)
+}
+
+func TestRecordWithError(t *testing.T) {
+	ok := newAuditRecord("router-a.tinfoil.sh")
+	ok.Status = statusOK
+	rec := recordWithError(ok, errors.New("reading known enclaves: permission denied"))
+	assert.Equal(t, statusError, rec.Status)
+	assert.Equal(t, reasonFetchError, rec.Reason)
+	assert.Error(t, routerPoolError([]*auditRecord{rec}))
+
+	bad := newAuditRecord("router-b.tinfoil.sh")
+	bad.fail(reasonMeasurementMismatch, "PCR register mismatch")
+	rec = recordWithError(bad, bad.err())
+	assert.Equal(t, reasonMeasurementMismatch, rec.Reason, "a recorded failure is kept")
+}
+
+func TestRouterPoolError(t *testing.T) {
+	ok := newAuditRecord("router-a.tinfoil.sh")
+	ok.Status = statusOK
+	assert.NoError(t, routerPoolError([]*auditRecord{ok}))
+
+	bad := newAuditRecord("router-b.tinfoil.sh")
+	bad.fail(reasonMeasurementMismatch, "PCR register mismatch")
+	unreachable := newAuditRecord("router-c.tinfoil.sh")
+	unreachable.fail(reasonFetchError, "dial tcp: timeout")
+
+	err := routerPoolError([]*auditRecord{ok, bad, unreachable})
+	assert.EqualError(t, err, "2 of 3 routers failed verification: PCR register mismatch")
+	assert.Equal(t, exitMeasurementMismatch, exitCode(err))
+
+	var out bytes.Buffer
+	renderRouterVerifications(&out, []*auditRecord{ok, bad})
+	assert.Contains(t, out.String(), "router-b.tinfoil.sh")
+	assert.Contains(t, out.String(), "PCR register mismatch")
+	assert.NotContains(t, out.String(), "not compared with a release")
+
+	enclaveOnly := newAuditRecord("router-d.tinfoil.sh")
+	enclaveOnly.Status = statusEnclaveOnly
+	out.Reset()
+	renderRouterVerifications(&out, []*auditRecord{ok, enclaveOnly})
+	assert.Contains(t, out.String(), "1 of 2 routers were not compared with a release")
+}
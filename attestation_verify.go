@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/ed25519"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -14,16 +15,50 @@ func init() {
 	attestationCmd.AddCommand(attestationVerifyCmd)
 	attestationVerifyCmd.Flags().BoolVarP(&jsonOutput, "json", "j", false, "Output in JSON format")
 	attestationVerifyCmd.Flags().StringVarP(&jsonFile, "log-file", "l", "", "Path to write the JSON log")
+	attestationVerifyCmd.Flags().StringVar(&releaseTag, "tag", "", "Verify against this release tag instead of the latest release")
+	attestationVerifyCmd.Flags().StringVar(&releaseDigest, "digest", "", "Verify against this release digest instead of the latest release")
+	attestationVerifyCmd.Flags().StringVar(&auditLogPath, "audit-log", "", "Append the record to this hash-chained JSONL audit log")
+	attestationVerifyCmd.Flags().StringVar(&reportFormat, "format", "json", "Format of the JSON output: json (verification record) or vsa (in-toto Verification Summary Attestation)")
+	attestationVerifyCmd.Flags().StringVar(&signKeyPath, "sign-key", "", "Sign the JSON output with this ed25519 private key (PEM)")
+	attestationVerifyCmd.Flags().StringVar(&policyPath, "policy", "", "Verify against the allowlist in this policy file instead of a single release")
+	attestationVerifyCmd.Flags().StringSliceVar(&platformRequirements.MinTCB, "min-tcb", nil, minTCBUsage)
+	attestationVerifyCmd.Flags().BoolVar(&platformRequirements.NoDebug, "no-debug", false, noDebugUsage)
+	attestationVerifyCmd.Flags().BoolVar(&modeAllowances.Debug, "allow-debug", false, allowDebugUsage)
+	attestationVerifyCmd.Flags().BoolVar(&modeAllowances.NonCC, "allow-non-cc", false, allowNonCCUsage)
+	attestationVerifyCmd.Flags().BoolVar(&allowNoHPKE, "allow-no-hpke", false, allowNoHPKEUsage)
+	attestationVerifyCmd.Flags().StringVar(&knownEnclavesMode, "known-enclaves", knownEnclavesWarn, knownEnclavesUsage)
+	attestationVerifyCmd.Flags().BoolVar(&rekorOptions.Show, "rekor", false, rekorUsage)
+	attestationVerifyCmd.Flags().StringVar(&rekorOptions.Checkpoint, "rekor-checkpoint", "", rekorCheckpointUsage)
+	attestationVerifyCmd.Flags().StringVar(&trustRootPath, "trust-root", "", trustRootUsage)
+	attestationVerifyCmd.Flags().StringVar(&offlineDir, "offline", "", "Verify saved evidence from this directory without network access")
+	attestationVerifyCmd.Flags().BoolVar(&verifyAllRouters, "all-routers", false, "Verify every router in the pool the default client picks from")
+	attestationVerifyCmd.Flags().IntVar(&verifyConcurrency, "concurrency", 4, "Number of routers to verify at once with --all-routers")
 }
 
 var (
-	jsonOutput bool
-	jsonFile   string
+	jsonOutput   bool
+	jsonFile     string
+	offlineDir   string
+	reportFormat string
 )
 
 var attestationVerifyCmd = &cobra.Command{
 	Use:   "verify",
 	Short: "Verify enclave attestation",
+	Long: `Verify that an enclave runs the expected code.
+
+Exit status is 0 when verification passes and 1 for usage and other errors.
+Verification failures exit with a code for their reason:
+
+  10  measurement_mismatch  enclave measurement differs from the release
+  11  key_mismatch          TLS key is not the attested key
+  12  attestation_invalid   attestation document failed hardware verification
+  13  policy_violation      enclave does not meet the policy's requirements
+  14  known_enclave_changed known enclave changed without a new release
+  20  sigstore_error        release bundle failed Sigstore verification
+  30  fetch_error           evidence could not be fetched (network, GitHub)
+  31  evidence_error        saved evidence could not be loaded`,
+	SilenceUsage: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		logger := log.New()
 		if jsonFile != "" || jsonOutput {
@@ -37,19 +72,88 @@ var attestationVerifyCmd = &cobra.Command{
 			logger.SetLevel(log.TraceLevel)
 		}
 
-		record, err := verifyAttestation(logger)
-		if err != nil {
+		if reportFormat != "json" && reportFormat != "vsa" {
+			return fmt.Errorf("unknown --format %q: expected json or vsa", reportFormat)
+		}
+
+		if offlineDir != "" && policyPath != "" {
+			return fmt.Errorf("--policy cannot be used with --offline")
+		}
+		if policyPath != "" && rekorEnabled() {
+			return fmt.Errorf("--rekor and --rekor-checkpoint cannot be used with --policy")
+		}
+		if err := validateKnownEnclavesMode(); err != nil {
+			return err
+		}
+		if _, err := parseMinTCB(platformRequirements.MinTCB); err != nil {
 			return err
 		}
+		if verifyAllRouters && (enclaveHost != "" || offlineDir != "") {
+			return fmt.Errorf("--all-routers cannot be used with --host or --offline")
+		}
+		if verifyConcurrency < 1 {
+			return fmt.Errorf("--concurrency must be at least 1")
+		}
+		if signKeyPath != "" && !jsonOutput && jsonFile == "" {
+			return fmt.Errorf("--sign-key requires -j or -l, since only the JSON output is signed")
+		}
+
+		var signKey ed25519.PrivateKey
+		if signKeyPath != "" {
+			var err error
+			if signKey, err = readPrivateKey(signKeyPath); err != nil {
+				return err
+			}
+		}
+
+		if verifyAllRouters {
+			return verifyRouterPool(logger, signKey)
+		}
+
+		var record *auditRecord
+		var verifyErr error
+		if offlineDir != "" {
+			ev, err := loadEvidence(logger, offlineDir, currentVerifyOptions())
+			if err == nil && trustRootPath != "" {
+				// A pinned trust root replaces the captured one.
+				ev.TrustRoot, err = fetchTrustRoot()
+				ev.TrustRootSource = trustRootSource()
+			}
+			if err != nil {
+				verifyErr = &verificationError{Reason: reasonEvidenceError, Err: err}
+				record = failedRecord(enclaveHost, repo, verifyErr)
+			} else {
+				record, verifyErr = verifyEvidence(logger, ev)
+			}
+		} else {
+			record, verifyErr = verifyLive(logger, currentVerifyOptions())
+		}
+		if record == nil {
+			return verifyErr
+		}
+
+		if !jsonOutput {
+			explainRecord(os.Stdout, record)
+			if verbose && record.Platform != nil {
+				fmt.Println()
+				printPlatformReport(os.Stdout, record.Platform)
+			}
+		}
+
+		if auditLogPath != "" {
+			if err := appendAuditLog(auditLogPath, record); err != nil {
+				return err
+			}
+		}
 
-		output, err := json.MarshalIndent(record, "", "  ")
+		output, err := formatReport(record, signKey)
 		if err != nil {
 			return fmt.Errorf("error marshaling JSON: %v", err)
 		}
 
 		if jsonOutput {
 			fmt.Println(string(output))
-			return nil
+			return verifyErr
 		}
 
 		if jsonFile != "" {
@@ -58,6 +162,47 @@ var attestationVerifyCmd = &cobra.Command{
 			}
 		}
 
-		return nil
+		return verifyErr
 	},
 }
+
+// verifyLive runs verifyAttestation and, when it passes, checks the result
+// against known_enclaves.
+func verifyLive(l *log.Logger, opts verifyOptions) (*auditRecord, error) {
+	record, err := verifyAttestation(l, opts)
+	if err != nil {
+		return record, err
+	}
+	if err := checkKnownEnclave(l, record); err != nil {
+		if reasonOf(err) == "" {
+			return record, err
+		}
+		record.fail(reasonOf(err), err.Error())
+		return record, record.err()
+	}
+	return record, nil
+}
+
+// formatReport encodes record in the --format output format, signing it
+// when a key is given.
+func formatReport(record *auditRecord, signKey ed25519.PrivateKey) ([]byte, error) {
+	var report any = record
+	payloadType := reportPayloadType
+	if reportFormat == "vsa" {
+		vsa, err := buildVSA(record)
+		if err != nil {
+			return nil, err
+		}
+		report = vsa
+		payloadType = inTotoPayloadType
+	}
+	if signKey == nil {
+		return json.MarshalIndent(report, "", "  ")
+	}
+
+	payload, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(signEnvelope(payloadType, payload, signKey), "", "  ")
+}
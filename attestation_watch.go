@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	watchTargets  []string
+	watchInterval time.Duration
+	watchCount    int
+	watchExec     string
+	watchWebhook  string
+)
+
+func init() {
+	attestationCmd.AddCommand(attestationWatchCmd)
+	attestationWatchCmd.Flags().StringArrayVar(&watchTargets, "target", nil, "Enclave to watch as host or host=owner/repo; may be repeated (defaults to -e/-r)")
+	attestationWatchCmd.Flags().DurationVar(&watchInterval, "interval", 5*time.Minute, "Time between checks")
+	attestationWatchCmd.Flags().IntVar(&watchCount, "count", 0, "Stop after this many rounds of checks (0 runs until interrupted)")
+	attestationWatchCmd.Flags().StringVar(&watchExec, "exec", "", "Shell command to run when an enclave changes; the event JSON is passed on stdin")
+	attestationWatchCmd.Flags().StringVar(&watchWebhook, "webhook", "", "URL to POST the event JSON to when an enclave changes")
+	attestationWatchCmd.Flags().StringVar(&auditLogPath, "audit-log", "", "Append every check to this hash-chained JSONL audit log")
+}
+
+// watchEvent is emitted as one JSON line for every check.
+type watchEvent struct {
+	Time    string       `json:"time"`
+	Enclave string       `json:"enclave"`
+	Repo    string       `json:"repo,omitempty"`
+	Status  string       `json:"status"`
+	Reason  string       `json:"reason,omitempty"`
+	Changed bool         `json:"changed"`
+	Changes []string     `json:"changes,omitempty"`
+	Record  *auditRecord `json:"record"`
+}
+
+var attestationWatchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Continuously re-verify enclaves and report changes",
+	Long: `Re-run attestation verification on a schedule for one or more enclaves and
+print one JSON line per check. A check is flagged as changed when the status,
+TLS key fingerprint, measurement or release digest differs from the previous
+check of the same enclave, or when the first check does not pass. Changes can
+trigger a shell command (--exec) or a webhook POST (--webhook).`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if watchInterval <= 0 {
+			return fmt.Errorf("--interval must be positive")
+		}
+		targets, err := parseWatchTargets(watchTargets)
+		if err != nil {
+			return err
+		}
+
+		logger := log.New()
+		if verbose {
+			logger.SetLevel(log.DebugLevel)
+		} else if trace {
+			logger.SetLevel(log.TraceLevel)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		w := &watcher{
+			logger: logger,
+			out:    json.NewEncoder(os.Stdout),
+			last:   map[watchKey]*auditRecord{},
+		}
+		ticker := time.NewTicker(watchInterval)
+		defer ticker.Stop()
+		for round := 1; ; round++ {
+			w.checkAll(targets)
+			if watchCount > 0 && round >= watchCount {
+				return nil
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+		}
+	},
+}
+
+// parseWatchTargets turns --target values into verifyOptions, falling back
+// to the global -e/-r flags when none are given.
+func parseWatchTargets(raw []string) ([]verifyOptions, error) {
+	if len(raw) == 0 {
+		if enclaveHost == "" {
+			return nil, fmt.Errorf("specify --target or --host")
+		}
+		return []verifyOptions{{Host: enclaveHost, Repo: repo}}, nil
+	}
+	targets := make([]verifyOptions, 0, len(raw))
+	for _, t := range raw {
+		host, targetRepo, _ := strings.Cut(t, "=")
+		host = strings.TrimSpace(host)
+		targetRepo = strings.TrimSpace(targetRepo)
+		if host == "" {
+			return nil, fmt.Errorf("invalid target %q: expected host or host=owner/repo", t)
+		}
+		targets = append(targets, verifyOptions{Host: host, Repo: targetRepo})
+	}
+	return targets, nil
+}
+
+type watcher struct {
+	logger *log.Logger
+
+	mu   sync.Mutex
+	out  *json.Encoder
+	last map[watchKey]*auditRecord
+}
+
+// watchKey identifies a target. The same host may be watched against
+// several repos, and each keeps its own previous record.
+type watchKey struct {
+	Host string
+	Repo string
+}
+
+// remember stores record as the latest result for target and returns the
+// previous one. The caller holds w.mu.
+func (w *watcher) remember(target verifyOptions, record *auditRecord) *auditRecord {
+	key := watchKey{Host: target.Host, Repo: target.Repo}
+	prev := w.last[key]
+	w.last[key] = record
+	return prev
+}
+
+func (w *watcher) checkAll(targets []verifyOptions) {
+	var wg sync.WaitGroup
+	for _, t := range targets {
+		wg.Add(1)
+		go func(t verifyOptions) {
+			defer wg.Done()
+			w.check(t)
+		}(t)
+	}
+	wg.Wait()
+}
+
+func (w *watcher) check(target verifyOptions) {
+	record, _ := verifyAttestation(w.logger, target)
+	if auditLogPath != "" {
+		if err := appendAuditLog(auditLogPath, record); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+		}
+	}
+
+	w.mu.Lock()
+	prev := w.remember(target, record)
+	changes := watchChanges(prev, record)
+	event := watchEvent{
+		Time:    time.Now().UTC().Format(time.RFC3339),
+		Enclave: target.Host,
+		Repo:    target.Repo,
+		Status:  record.Status,
+		Reason:  record.Reason,
+		Changed: len(changes) > 0,
+		Changes: changes,
+		Record:  record,
+	}
+	if err := w.out.Encode(event); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: writing event: %v\n", err)
+	}
+	w.mu.Unlock()
+
+	if event.Changed {
+		notifyWatchChange(event)
+	}
+}
+
+// watchChanges describes how cur differs from prev. The first check of an
+// enclave has no previous record and only counts as a change if it failed.
+func watchChanges(prev, cur *auditRecord) []string {
+	if prev == nil {
+		if cur.Status != statusOK && cur.Status != statusEnclaveOnly {
+			return []string{fmt.Sprintf("status: %s (%s)", cur.Status, cur.Reason)}
+		}
+		return nil
+	}
+
+	var changes []string
+	if prev.Status != cur.Status {
+		changes = append(changes, fmt.Sprintf("status: %s -> %s", prev.Status, cur.Status))
+	}
+	if prev.Keys.Enclave != "" && cur.Keys.Enclave != "" && prev.Keys.Enclave != cur.Keys.Enclave {
+		changes = append(changes, fmt.Sprintf("tls key: %s -> %s", prev.Keys.Enclave, cur.Keys.Enclave))
+	}
+	if prev.Digest != "" && cur.Digest != "" && prev.Digest != cur.Digest {
+		changes = append(changes, fmt.Sprintf("digest: %s -> %s", prev.Digest, cur.Digest))
+	}
+	if prev.Measurements.Enclave != nil && cur.Measurements.Enclave != nil &&
+		!sameMeasurement(prev.Measurements.Enclave, cur.Measurements.Enclave) {
+		changes = append(changes, "measurement changed")
+	}
+	return changes
+}
+
+// notifyWatchChange runs the --exec hook and POSTs to --webhook. Failures
+// are reported on stderr but never stop the watch.
+func notifyWatchChange(event watchEvent) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: encoding event: %v\n", err)
+		return
+	}
+
+	if watchExec != "" {
+		hook := exec.Command("sh", "-c", watchExec)
+		hook.Stdin = bytes.NewReader(payload)
+		hook.Stdout = os.Stderr
+		hook.Stderr = os.Stderr
+		hook.Env = append(os.Environ(),
+			"TINFOIL_WATCH_ENCLAVE="+event.Enclave,
+			"TINFOIL_WATCH_STATUS="+event.Status,
+			"TINFOIL_WATCH_REASON="+event.Reason,
+		)
+		if err := hook.Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: --exec hook failed: %v\n", err)
+		}
+	}
+
+	if watchWebhook != "" {
+		hc := &http.Client{Timeout: 10 * time.Second}
+		resp, err := hc.Post(watchWebhook, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: webhook failed: %v\n", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			fmt.Fprintf(os.Stderr, "warning: webhook returned %s\n", resp.Status)
+		}
+	}
+}
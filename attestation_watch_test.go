@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tinfoilsh/tinfoil-go/verifier/attestation"
+)
+
+func TestParseWatchTargets(t *testing.T) {
+	targets, err := parseWatchTargets([]string{"a.tinfoil.sh=tinfoilsh/a", " b.tinfoil.sh "})
+	require.NoError(t, err)
+	assert.Equal(t, []verifyOptions{
+		{Host: "a.tinfoil.sh", Repo: "tinfoilsh/a"},
+		{Host: "b.tinfoil.sh"},
+	}, targets)
+
+	_, err = parseWatchTargets([]string{"=tinfoilsh/a"})
+	assert.Error(t, err)
+}
+
+func TestWatchChanges(t *testing.T) {
+	ok := newAuditRecord("a.tinfoil.sh")
+	ok.Status = statusOK
+	ok.Digest = "d1"
+	ok.Keys.Enclave = "k1"
+	ok.Measurements.Enclave = &attestation.Measurement{Type: "t", Registers: []string{"r1"}}
+
+	assert.Empty(t, watchChanges(nil, ok), "first passing check is not a change")
+	assert.Empty(t, watchChanges(ok, ok))
+
+	failed := failedRecord("a.tinfoil.sh", "", verifyErrorf(reasonFetchError, "down"))
+	assert.Len(t, watchChanges(nil, failed), 1, "first failing check is a change")
+	assert.Equal(t, []string{"status: ok -> error"}, watchChanges(ok, failed))
+
+	rotated := *ok
+	rotated.Keys.Enclave = "k2"
+	rotated.Measurements.Enclave = &attestation.Measurement{Type: "t", Registers: []string{"r2"}}
+	assert.Equal(t, []string{"tls key: k1 -> k2", "measurement changed"}, watchChanges(ok, &rotated))
+}
+
+func TestWatcherKeepsStatePerHostAndRepo(t *testing.T) {
+	w := &watcher{last: map[watchKey]*auditRecord{}}
+	a := verifyOptions{Host: "e.tinfoil.sh", Repo: "tinfoilsh/a"}
+	b := verifyOptions{Host: "e.tinfoil.sh", Repo: "tinfoilsh/b"}
+	recA, recB := &auditRecord{Repo: "tinfoilsh/a"}, &auditRecord{Repo: "tinfoilsh/b"}
+
+	assert.Nil(t, w.remember(a, recA))
+	assert.Nil(t, w.remember(b, recB))
+	assert.Same(t, recA, w.remember(a, &auditRecord{}))
+	assert.Same(t, recB, w.remember(b, &auditRecord{}))
+}
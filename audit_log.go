@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"syscall"
+
+	"github.com/spf13/cobra"
+)
+
+// An audit log is a JSONL file of auditRecords. Each appended record carries
+// a sequence number, the hash of the previous entry, and its own hash: the
+// sha256 of the entry's JSON encoding without the trailing "hash" field.
+// Editing, removing or reordering entries breaks the chain, which
+// `attestation log verify` detects. Truncating the newest entries cannot be
+// detected from the log alone; keep a copy of the last hash to cover that.
+
+var auditLogPath string
+
+var auditHashSuffix = regexp.MustCompile(`,"hash":"([0-9a-f]{64})"}$`)
+
+func init() {
+	attestationCmd.AddCommand(attestationLogCmd)
+	attestationLogCmd.AddCommand(attestationLogVerifyCmd)
+}
+
+var attestationLogCmd = &cobra.Command{
+	Use:   "log",
+	Short: "Audit log commands",
+}
+
+var attestationLogVerifyCmd = &cobra.Command{
+	Use:   "verify <file>",
+	Short: "Check the hash chain of an audit log",
+	Long: `Check that every entry of an audit log written with --audit-log is intact:
+each entry's hash matches its contents, sequence numbers have no gaps, and
+each entry refers to the hash of the one before it.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return fmt.Errorf("reading audit log: %v", err)
+		}
+		count, head, problems := verifyAuditLog(data)
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("audit log %s failed verification with %d problem(s)", args[0], len(problems))
+		}
+		fmt.Printf("Verified %d entries in %s\n", count, args[0])
+		if head != "" {
+			fmt.Printf("Last hash: %s\n", head)
+		}
+		return nil
+	},
+}
+
+// auditChainLink holds the chain fields of one audit log entry.
+type auditChainLink struct {
+	Seq      uint64 `json:"seq"`
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+// auditLogEntry is one line of an audit log: the record followed by its
+// chain fields. Hash must stay the last field.
+type auditLogEntry struct {
+	*auditRecord
+	Seq      uint64 `json:"seq,omitempty"`
+	PrevHash string `json:"prev_hash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// appendAuditLog links record to the last entry of the log at path and
+// appends it. The log is locked with flock while its tail is read and the
+// entry written, so concurrent tinfoil processes cannot fork the chain.
+func appendAuditLog(path string, record *auditRecord) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("opening audit log: %v", err)
+	}
+	if err := appendLockedAuditLog(f, record); err != nil {
+		f.Close()
+		return err
+	}
+	// Closing the file releases the lock.
+	return f.Close()
+}
+
+func appendLockedAuditLog(f *os.File, record *auditRecord) error {
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("locking audit log: %v", err)
+	}
+	last, err := lastAuditEntry(f)
+	if err != nil {
+		return err
+	}
+
+	entry := auditLogEntry{auditRecord: record, Seq: last.Seq + 1, PrevHash: last.Hash}
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("encoding audit record: %v", err)
+	}
+	entry.Hash = sha256Hex(body)
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("encoding audit record: %v", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("writing audit log: %v", err)
+	}
+	return nil
+}
+
+// lastAuditEntry returns the chain fields of the last entry in the log r,
+// or a zero link if the log is empty.
+func lastAuditEntry(r io.Reader) (auditChainLink, error) {
+	var last auditChainLink
+	var lastLine []byte
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			lastLine = append(lastLine[:0], line...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return last, fmt.Errorf("reading audit log: %v", err)
+	}
+	if lastLine == nil {
+		return last, nil
+	}
+	last, err := parseAuditEntry(lastLine)
+	if err != nil {
+		return last, fmt.Errorf("last audit log entry is invalid, refusing to append: %v", err)
+	}
+	return last, nil
+}
+
+// parseAuditEntry decodes the chain fields of one log line and checks the
+// entry's own hash.
+func parseAuditEntry(line []byte) (auditChainLink, error) {
+	var link auditChainLink
+	if err := json.Unmarshal(line, &link); err != nil {
+		return link, fmt.Errorf("invalid JSON: %v", err)
+	}
+	m := auditHashSuffix.FindSubmatchIndex(line)
+	if m == nil {
+		return link, fmt.Errorf("missing hash")
+	}
+	body := append(append([]byte{}, line[:m[0]]...), '}')
+	if got := sha256Hex(body); got != link.Hash {
+		return link, fmt.Errorf("hash mismatch: entry was modified (computed %s)", got)
+	}
+	return link, nil
+}
+
+// verifyAuditLog checks every entry of an audit log and returns the number
+// of entries, the hash of the last one, and a description of each problem.
+func verifyAuditLog(data []byte) (int, string, []string) {
+	var problems []string
+	var prev auditChainLink
+	count := 0
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		lineNo := i + 1
+		if len(bytes.TrimSpace(line)) == 0 {
+			if i != len(lines)-1 {
+				problems = append(problems, fmt.Sprintf("line %d: empty line", lineNo))
+			}
+			continue
+		}
+		count++
+
+		link, err := parseAuditEntry(line)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: %v", lineNo, err))
+		}
+		if link.Seq != prev.Seq+1 {
+			problems = append(problems, fmt.Sprintf("line %d: sequence %d follows %d: entries missing or reordered", lineNo, link.Seq, prev.Seq))
+		}
+		if link.PrevHash != prev.Hash {
+			problems = append(problems, fmt.Sprintf("line %d: previous hash does not match the preceding entry", lineNo))
+		}
+		prev = link
+	}
+	return count, prev.Hash, problems
+}
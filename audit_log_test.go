@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestAuditLog(t *testing.T, n int) (string, [][]byte) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	for i := 0; i < n; i++ {
+		rec := newAuditRecord("enclave.example.com")
+		rec.Status = statusOK
+		require.NoError(t, appendAuditLog(path, rec))
+	}
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n"))
+	for i, line := range lines {
+		link, err := parseAuditEntry(line)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(i+1), link.Seq)
+	}
+	return path, lines
+}
+
+func joinLines(lines ...[]byte) []byte {
+	return append(bytes.Join(lines, []byte("\n")), '\n')
+}
+
+func TestAuditLogChain(t *testing.T) {
+	_, lines := writeTestAuditLog(t, 3)
+	require.Len(t, lines, 3)
+
+	count, head, problems := verifyAuditLog(joinLines(lines...))
+	assert.Empty(t, problems)
+	assert.Equal(t, 3, count)
+	last, err := parseAuditEntry(lines[2])
+	require.NoError(t, err)
+	assert.Equal(t, last.Hash, head)
+}
+
+func TestAuditLogTampering(t *testing.T) {
+	_, lines := writeTestAuditLog(t, 3)
+
+	edited := bytes.Replace(lines[1], []byte(`"status":"ok"`), []byte(`"status":"fail"`), 1)
+	_, _, problems := verifyAuditLog(joinLines(lines[0], edited, lines[2]))
+	require.Len(t, problems, 1)
+	assert.Contains(t, problems[0], "line 2: hash mismatch")
+
+	_, _, problems = verifyAuditLog(joinLines(lines[0], lines[2]))
+	assert.NotEmpty(t, problems)
+	assert.Contains(t, strings.Join(problems, "\n"), "missing or reordered")
+
+	_, _, problems = verifyAuditLog(joinLines(lines[0], lines[2], lines[1]))
+	assert.NotEmpty(t, problems)
+}
+
+func TestAuditLogRefusesCorruptTail(t *testing.T) {
+	path, _ := writeTestAuditLog(t, 1)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString("{\"seq\":2}\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	assert.Error(t, appendAuditLog(path, newAuditRecord("enclave.example.com")))
+}
+
+func TestAuditLogConcurrentAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, appendAuditLog(path, newAuditRecord("enclave.example.com")))
+		}()
+	}
+	wg.Wait()
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	count, _, problems := verifyAuditLog(data)
+	assert.Empty(t, problems)
+	assert.Equal(t, 20, count)
+}
+
+func TestAuditRecordOmitsChainFields(t *testing.T) {
+	_, lines := writeTestAuditLog(t, 2)
+	assert.Contains(t, string(lines[1]), `"prev_hash":`)
+
+	rec := newAuditRecord("enclave.example.com")
+	data, err := json.Marshal(rec)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), `"seq"`)
+	assert.NotContains(t, string(data), `"hash"`)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// noCache backs the --no-cache flag.
+var noCache bool
+
+// Kinds of cached objects, each stored in its own subdirectory.
+const (
+	cacheKindRelease   = "releases"
+	cacheKindBundle    = "bundles"
+	cacheKindTrustRoot = "trust_roots"
+)
+
+// How long cached lookups are trusted before they are refreshed. Bundles
+// are keyed by digest and do not change, but still expire so that a
+// deleted attestation is eventually noticed.
+const (
+	cacheTTLLatestRelease = 5 * time.Minute
+	cacheTTLTaggedRelease = time.Hour
+	cacheTTLTrustRoot     = 24 * time.Hour
+	cacheTTLBundle        = 7 * 24 * time.Hour
+)
+
+// How long past its TTL an entry may still be used, with a warning, when
+// refreshing it fails. The latest release and the trust root have no grace
+// period: a stale answer there could hide a new release or a rotated key.
+const (
+	cacheMaxStaleTaggedRelease = 24 * time.Hour
+	cacheMaxStaleBundle        = 24 * time.Hour
+)
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&noCache, "no-cache", false, "Do not read or write the release, bundle and trust root cache")
+	rootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cacheClearCmd)
+}
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the local verification cache",
+}
+
+var cacheClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Delete all cached releases, bundles and trust roots",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, err := cacheDir()
+		if err != nil {
+			return err
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("clearing cache: %w", err)
+		}
+		fmt.Printf("Cleared %s\n", dir)
+		return nil
+	},
+}
+
+func cacheDir() (string, error) {
+	if d := os.Getenv(envCacheDir); d != "" {
+		return d, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("locating home directory: %w", err)
+	}
+	return filepath.Join(home, ".tinfoil", "cache"), nil
+}
+
+// cachePath returns the file for key within kind. Keys are hashed so that
+// any string (URLs, repo names, tags) maps to a safe file name.
+func cachePath(kind, key string) (string, error) {
+	dir, err := cacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(dir, kind, hex.EncodeToString(sum[:])), nil
+}
+
+// cachedFetch returns the cached value for key if it is younger than ttl,
+// and otherwise calls fetch and caches the result. If fetch fails and the
+// entry expired less than maxStale ago, the expired entry is returned so
+// that verification keeps working through brief outages. A maxStale of 0
+// disables the fallback.
+func cachedFetch(kind, key string, ttl, maxStale time.Duration, fetch func() ([]byte, error)) ([]byte, error) {
+	if noCache {
+		return fetch()
+	}
+	path, err := cachePath(kind, key)
+	if err != nil {
+		return fetch()
+	}
+
+	cached, readErr := os.ReadFile(path)
+	var age time.Duration
+	if readErr == nil {
+		if info, err := os.Stat(path); err == nil {
+			age = time.Since(info.ModTime())
+		}
+		if age < ttl {
+			return cached, nil
+		}
+	}
+
+	data, err := fetch()
+	if err != nil {
+		if readErr == nil && age < ttl+maxStale {
+			fmt.Fprintf(os.Stderr, "warning: %v; using cached %s from %s ago\n", err, kind, age.Round(time.Second))
+			return cached, nil
+		}
+		return nil, err
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: writing cache: %v\n", err)
+	}
+	return data, nil
+}
+
+// writeFileAtomic replaces path atomically so concurrent verifications never
+// read a partial entry.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
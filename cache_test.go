@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachedFetch(t *testing.T) {
+	t.Setenv(envCacheDir, t.TempDir())
+	calls := 0
+	fetch := func(value string, err error) func() ([]byte, error) {
+		return func() ([]byte, error) {
+			calls++
+			return []byte(value), err
+		}
+	}
+
+	data, err := cachedFetch(cacheKindRelease, "k", time.Hour, 24*time.Hour, fetch("v1", nil))
+	require.NoError(t, err)
+	assert.Equal(t, "v1", string(data))
+
+	data, err = cachedFetch(cacheKindRelease, "k", time.Hour, 24*time.Hour, fetch("v2", nil))
+	require.NoError(t, err)
+	assert.Equal(t, "v1", string(data), "fresh entry is served from the cache")
+	assert.Equal(t, 1, calls)
+
+	path, err := cachePath(cacheKindRelease, "k")
+	require.NoError(t, err)
+	old := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(path, old, old))
+
+	data, err = cachedFetch(cacheKindRelease, "k", time.Hour, 24*time.Hour, fetch("", errors.New("offline")))
+	require.NoError(t, err)
+	assert.Equal(t, "v1", string(data), "expired entry is used when the refresh fails")
+
+	data, err = cachedFetch(cacheKindRelease, "k", time.Hour, 24*time.Hour, fetch("v3", nil))
+	require.NoError(t, err)
+	assert.Equal(t, "v3", string(data), "expired entry is refreshed")
+
+	_, err = cachedFetch(cacheKindRelease, "other", time.Hour, 24*time.Hour, fetch("", errors.New("offline")))
+	assert.EqualError(t, err, "offline")
+}
+
+func TestCachedFetchBoundsStaleFallback(t *testing.T) {
+	t.Setenv(envCacheDir, t.TempDir())
+	offline := func() ([]byte, error) { return nil, errors.New("offline") }
+
+	_, err := cachedFetch(cacheKindRelease, "k", time.Hour, time.Hour, func() ([]byte, error) { return []byte("v1"), nil })
+	require.NoError(t, err)
+	path, err := cachePath(cacheKindRelease, "k")
+	require.NoError(t, err)
+
+	old := time.Now().Add(-3 * time.Hour)
+	require.NoError(t, os.Chtimes(path, old, old))
+	_, err = cachedFetch(cacheKindRelease, "k", time.Hour, time.Hour, offline)
+	assert.EqualError(t, err, "offline", "entry expired longer ago than maxStale")
+
+	old = time.Now().Add(-90 * time.Minute)
+	require.NoError(t, os.Chtimes(path, old, old))
+	_, err = cachedFetch(cacheKindRelease, "k", time.Hour, 0, offline)
+	assert.EqualError(t, err, "offline", "no fallback without maxStale")
+}
+
+func TestCachedFetchNoCache(t *testing.T) {
+	t.Setenv(envCacheDir, t.TempDir())
+	defer func(v bool) { noCache = v }(noCache)
+	noCache = true
+
+	_, err := cachedFetch(cacheKindBundle, "k", cacheTTLBundle, cacheMaxStaleBundle, func() ([]byte, error) { return []byte("v1"), nil })
+	require.NoError(t, err)
+	path, err := cachePath(cacheKindBundle, "k")
+	require.NoError(t, err)
+	assert.NoFileExists(t, path)
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base32"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/tinfoilsh/tinfoil-go/verifier/attestation"
+)
+
+// certAttestationLabel is the DNS label that marks a certificate SAN as a
+// chunk of the enclave's attestation document. Each such SAN has the form
+// <NN><chunk>.hatt.<domain>, where NN is the two-digit chunk index and the
+// concatenated chunks are the unpadded, lowercase base32 encoding of the
+// gzipped JSON attestation document.
+const certAttestationLabel = "hatt"
+
+// maxCertAttestationSize bounds the decompressed attestation document so a
+// hostile certificate cannot use the SANs as a gzip bomb.
+const maxCertAttestationSize = 1 << 20
+
+var (
+	certServer string
+	certFile   string
+)
+
+func init() {
+	rootCmd.AddCommand(certificateCmd)
+	certificateCmd.AddCommand(certificateAuditCmd)
+	certificateAuditCmd.Flags().StringVarP(&certServer, "server", "s", "", "Fetch the certificate from this server (host or host:port)")
+	certificateAuditCmd.Flags().StringVarP(&certFile, "cert", "c", "", "Read the certificate from this PEM file")
+	certificateAuditCmd.Flags().BoolVarP(&jsonOutput, "json", "j", false, "Output in JSON format")
+	certificateAuditCmd.Flags().StringVar(&releaseTag, "tag", "", "Compare against this release tag instead of the latest release")
+	certificateAuditCmd.Flags().StringVar(&releaseDigest, "digest", "", "Compare against this release digest instead of the latest release")
+}
+
+var certificateCmd = &cobra.Command{
+	Use:          "certificate",
+	Aliases:      []string{"cert"},
+	Short:        "Certificate commands",
+	SilenceUsage: true,
+}
+
+var certificateAuditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Verify that a TLS certificate matches the enclave's attestation",
+	Long: `Extract the attestation document embedded in the certificate's SANs and
+check it against the certificate's own public key, the attestation document
+served by the enclave, and (with --repo) the Sigstore measurement of the
+latest release, or the release selected by --tag or --digest.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if (certServer == "") == (certFile == "") {
+			return fmt.Errorf("specify exactly one of --server or --cert")
+		}
+
+		logger := log.New()
+		if jsonOutput {
+			logger.SetOutput(io.Discard)
+		}
+		if verbose {
+			logger.SetLevel(log.DebugLevel)
+		} else if trace {
+			logger.SetLevel(log.TraceLevel)
+		}
+
+		var cert *x509.Certificate
+		var err error
+		if certServer != "" {
+			cert, err = fetchServerCertificate(certServer)
+		} else {
+			cert, err = readCertificateFile(certFile)
+		}
+		if err != nil {
+			return err
+		}
+
+		record, verifyErr := auditCertificate(logger, cert)
+		if record == nil {
+			return verifyErr
+		}
+
+		if jsonOutput {
+			output, err := json.MarshalIndent(record, "", "  ")
+			if err != nil {
+				return fmt.Errorf("error marshaling JSON: %v", err)
+			}
+			fmt.Println(string(output))
+		} else {
+			printCertificateSummary(os.Stdout, record)
+		}
+		return verifyErr
+	},
+}
+
+// auditCertificate verifies the attestation carried in cert and cross-checks
+// it against the enclave's live attestation and, when repo is set, the
+// Sigstore measurement for the latest release. Like verifyAttestation, the
+// record describes the outcome even when an error is returned.
+func auditCertificate(l *log.Logger, cert *x509.Certificate) (*auditRecord, error) {
+	host := enclaveHost
+	if certServer != "" {
+		host = certServer
+		if h, _, err := net.SplitHostPort(certServer); err == nil {
+			host = h
+		}
+	}
+	if host == "" {
+		host = certificateHost(cert)
+	}
+	if host == "" {
+		return nil, fmt.Errorf("cannot determine enclave host from certificate; pass --host")
+	}
+
+	auditRec := newAuditRecord(host)
+
+	l.Println("Decoding attestation from certificate SANs")
+	certDoc, err := decodeCertAttestation(cert.DNSNames)
+	if err != nil {
+		auditRec.fail(reasonAttestationInvalid, err.Error())
+		return auditRec, auditRec.err()
+	}
+
+	l.Println("Verifying certificate attestation")
+	certVerification, err := certDoc.Verify()
+	if err != nil {
+		auditRec.fail(reasonAttestationInvalid, fmt.Sprintf("verifying certificate attestation: %v", err))
+		return auditRec, auditRec.err()
+	}
+	auditRec.Measurements.Cert = certVerification.Measurement
+	auditRec.Keys.Cert = certVerification.TLSPublicKeyFP
+
+	certFP, err := attestation.ConnectionCertFP(tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}})
+	if err != nil {
+		return auditRec, fmt.Errorf("computing certificate public key fingerprint: %v", err)
+	}
+	auditRec.Keys.Connection = certFP
+	l.Printf("Certificate public key fingerprint: %s", certFP)
+
+	if certFP != certVerification.TLSPublicKeyFP {
+		auditRec.fail(reasonKeyMismatch, "Certificate public key does not match the key attested in the certificate")
+		log.Printf("Certificate public key does not match the key attested in the certificate")
+	}
+
+	l.Printf("Fetching attestation doc from %s", host)
+	remoteAttestation, err := fetchAttestationDocument(host)
+	if err != nil {
+		auditRec.fail(reasonFetchError, fmt.Sprintf("fetching attestation document: %v", err))
+		return auditRec, auditRec.err()
+	}
+	verification, err := remoteAttestation.Verify()
+	if err != nil {
+		auditRec.fail(reasonAttestationInvalid, fmt.Sprintf("verifying attestation document: %v", err))
+		return auditRec, auditRec.err()
+	}
+	auditRec.Measurements.Enclave = verification.Measurement
+	auditRec.Keys.Enclave = verification.TLSPublicKeyFP
+
+	if verification.TLSPublicKeyFP != certVerification.TLSPublicKeyFP {
+		auditRec.fail(reasonKeyMismatch, "Enclave attestation key does not match the key attested in the certificate")
+		log.Printf("Enclave attestation key does not match the key attested in the certificate")
+	}
+	if err := certVerification.Measurement.Equals(verification.Measurement); err != nil {
+		auditRec.fail(reasonMeasurementMismatch, fmt.Sprintf("certificate and enclave measurements differ: %v", err))
+		log.Printf("Certificate and enclave measurements differ: %v", err)
+	}
+
+	if repo != "" {
+		auditRec.Repo = repo
+		tag, digest, codeMeasurements, err := fetchCodeMeasurement(l, repo, releaseTag, releaseDigest)
+		if err != nil {
+			auditRec.fail(reasonOf(err), err.Error())
+			return auditRec, err
+		}
+		auditRec.Tag = tag
+		auditRec.Digest = digest
+		auditRec.Measurements.Sigstore = *codeMeasurements
+
+		if err := codeMeasurements.Equals(certVerification.Measurement); err != nil {
+			auditRec.fail(reasonMeasurementMismatch, fmt.Sprintf("PCR register mismatch: %v", err))
+			log.Printf("PCR register mismatch. Verification failed: %v", err)
+			log.Printf("Differing registers: %s", strings.Join(differingRegisters(codeMeasurements, certVerification.Measurement), ", "))
+		} else {
+			l.Println("Certificate measurements match")
+		}
+	} else {
+		l.Warn("No repo specified, skipping code measurements")
+		l.Printf("Certificate measurement: %+v", certVerification.Measurement)
+	}
+
+	if auditRec.Status == "" {
+		auditRec.Status = statusOK
+		if repo == "" {
+			auditRec.Status = statusEnclaveOnly
+		}
+	}
+	return auditRec, auditRec.err()
+}
+
+// printCertificateSummary prints the outcome of a certificate audit.
+func printCertificateSummary(w io.Writer, r *auditRecord) {
+	switch r.Status {
+	case statusOK:
+		release := r.Tag
+		if release == "" {
+			release = "sha256:" + r.Digest
+		}
+		fmt.Fprintf(w, "PASS  certificate of %s matches its enclave and %s@%s\n", r.Enclave, r.Repo, release)
+	case statusEnclaveOnly:
+		fmt.Fprintf(w, "PASS  certificate of %s matches its enclave (no --repo, code measurement not checked)\n", r.Enclave)
+	default:
+		reason := r.Reason
+		if reason == "" {
+			reason = r.Status
+		}
+		fmt.Fprintf(w, "FAIL  certificate of %s: %s: %s\n", r.Enclave, reason, r.Error)
+	}
+}
+
+func fetchServerCertificate(server string) (*x509.Certificate, error) {
+	cs, err := tlsConnection(enclaveAddr(server))
+	if err != nil {
+		return nil, err
+	}
+	if len(cs.PeerCertificates) == 0 {
+		return nil, fmt.Errorf("%s presented no certificate", server)
+	}
+	return cs.PeerCertificates[0], nil
+}
+
+func readCertificateFile(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading certificate: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s does not contain a PEM certificate", path)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing certificate: %w", err)
+	}
+	return cert, nil
+}
+
+// certificateHost returns the first SAN that is not an attestation chunk.
+func certificateHost(cert *x509.Certificate) string {
+	for _, name := range cert.DNSNames {
+		if _, _, ok := splitAttestationSAN(name); !ok && !strings.HasPrefix(name, "*.") {
+			return name
+		}
+	}
+	return ""
+}
+
+// splitAttestationSAN reports whether name is an attestation chunk and, if
+// so, returns its index and payload.
+func splitAttestationSAN(name string) (int, string, bool) {
+	labels := strings.Split(name, ".")
+	if len(labels) < 3 || labels[1] != certAttestationLabel || len(labels[0]) < 3 {
+		return 0, "", false
+	}
+	idx, err := strconv.Atoi(labels[0][:2])
+	if err != nil {
+		return 0, "", false
+	}
+	return idx, labels[0][2:], true
+}
+
+// decodeCertAttestation reassembles the attestation document encoded in the
+// certificate SANs.
+func decodeCertAttestation(names []string) (*attestation.Document, error) {
+	chunks := map[int]string{}
+	for _, name := range names {
+		idx, payload, ok := splitAttestationSAN(name)
+		if !ok {
+			continue
+		}
+		if _, dup := chunks[idx]; dup {
+			return nil, fmt.Errorf("duplicate attestation SAN chunk %d", idx)
+		}
+		chunks[idx] = payload
+	}
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("certificate carries no attestation SANs")
+	}
+
+	indexes := make([]int, 0, len(chunks))
+	for idx := range chunks {
+		indexes = append(indexes, idx)
+	}
+	sort.Ints(indexes)
+	var encoded strings.Builder
+	for i, idx := range indexes {
+		if idx != i {
+			return nil, fmt.Errorf("attestation SAN chunk %d is missing", i)
+		}
+		encoded.WriteString(chunks[idx])
+	}
+
+	compressed, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(encoded.String()))
+	if err != nil {
+		return nil, fmt.Errorf("decoding attestation SANs: %w", err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("decompressing attestation SANs: %w", err)
+	}
+	raw, err := io.ReadAll(io.LimitReader(zr, maxCertAttestationSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("decompressing attestation SANs: %w", err)
+	}
+	if len(raw) > maxCertAttestationSize {
+		return nil, fmt.Errorf("certificate attestation exceeds %d bytes", maxCertAttestationSize)
+	}
+
+	var doc attestation.Document
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("parsing certificate attestation: %w", err)
+	}
+	return &doc, nil
+}
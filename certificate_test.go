@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encodeAttestationSANs(t *testing.T, doc string, chunkSize int) []string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write([]byte(doc))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	encoded := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf.Bytes()))
+	var names []string
+	for i := 0; len(encoded) > 0; i++ {
+		n := min(chunkSize, len(encoded))
+		names = append(names, fmt.Sprintf("%02d%s.%s.inference.tinfoil.sh", i, encoded[:n], certAttestationLabel))
+		encoded = encoded[n:]
+	}
+	return names
+}
+
+func TestDecodeCertAttestation(t *testing.T) {
+	names := encodeAttestationSANs(t, `{"format":"https://tinfoil.sh/predicate/sev-snp-guest/v2","body":"abc"}`, 40)
+	require.Greater(t, len(names), 1)
+
+	// SAN order in the certificate is not significant.
+	names[0], names[len(names)-1] = names[len(names)-1], names[0]
+	names = append([]string{"inference.tinfoil.sh"}, names...)
+
+	doc, err := decodeCertAttestation(names)
+	require.NoError(t, err)
+	assert.Equal(t, "https://tinfoil.sh/predicate/sev-snp-guest/v2", string(doc.Format))
+	assert.Equal(t, "abc", doc.Body)
+}
+
+func TestDecodeCertAttestationRejectsMissingChunk(t *testing.T) {
+	names := encodeAttestationSANs(t, `{"format":"x","body":"y"}`, 10)
+	require.Greater(t, len(names), 2)
+
+	_, err := decodeCertAttestation(append(names[:1], names[2:]...))
+	assert.ErrorContains(t, err, "missing")
+}
+
+func TestDecodeCertAttestationLimitsSize(t *testing.T) {
+	names := encodeAttestationSANs(t, strings.Repeat(" ", maxCertAttestationSize+1), 60)
+	require.Less(t, len(names), 100)
+
+	_, err := decodeCertAttestation(names)
+	assert.ErrorContains(t, err, "exceeds")
+}
+
+func TestPrintCertificateSummary(t *testing.T) {
+	var buf bytes.Buffer
+	printCertificateSummary(&buf, &auditRecord{Enclave: "a.example", Repo: "acme/app", Tag: "v1", Status: statusOK})
+	assert.Equal(t, "PASS  certificate of a.example matches its enclave and acme/app@v1\n", buf.String())
+
+	buf.Reset()
+	r := &auditRecord{Enclave: "a.example"}
+	r.fail(reasonKeyMismatch, "keys differ")
+	printCertificateSummary(&buf, r)
+	assert.Equal(t, "FAIL  certificate of a.example: key_mismatch: keys differ\n", buf.String())
+
+	buf.Reset()
+	printCertificateSummary(&buf, &auditRecord{Enclave: "a.example", Repo: "acme/app", Digest: "abcd", Status: statusOK})
+	assert.Equal(t, "PASS  certificate of a.example matches its enclave and acme/app@sha256:abcd\n", buf.String())
+
+	buf.Reset()
+	printCertificateSummary(&buf, failedRecord("a.example", "", errors.New("--tag and --digest require --repo")))
+	assert.Equal(t, "FAIL  certificate of a.example: error: --tag and --digest require --repo\n", buf.String())
+}
+
+func TestDecodeCertAttestationRequiresSANs(t *testing.T) {
+	_, err := decodeCertAttestation([]string{"inference.tinfoil.sh"})
+	assert.Error(t, err)
+}
+
+func TestSplitAttestationSAN(t *testing.T) {
+	idx, payload, ok := splitAttestationSAN("03abcdef.hatt.inference.tinfoil.sh")
+	require.True(t, ok)
+	assert.Equal(t, 3, idx)
+	assert.Equal(t, "abcdef", payload)
+
+	_, _, ok = splitAttestationSAN("inference.tinfoil.sh")
+	assert.False(t, ok)
+	_, _, ok = splitAttestationSAN("xxabc.hatt.tinfoil.sh")
+	assert.False(t, ok)
+}
@@ -13,14 +13,34 @@ import (
 const (
 	defaultControlplaneURL = "https://api.tinfoil.sh"
 
-	envAPIKey      = "TINFOIL_API_KEY"
-	envCPURL       = "TINFOIL_CONTROLPLANE_URL"
-	envConfigPath  = "TINFOIL_CONFIG"
+	envAPIKey        = "TINFOIL_API_KEY"
+	envCPURL         = "TINFOIL_CONTROLPLANE_URL"
+	envConfigPath    = "TINFOIL_CONFIG"
+	envTUFMirror     = "TINFOIL_TUF_MIRROR"
+	envCacheDir      = "TINFOIL_CACHE_DIR"
+	envKnownEnclaves = "TINFOIL_KNOWN_ENCLAVES"
+	envRouterListURL = "TINFOIL_ROUTERS_URL"
 )
 
 type cliConfig struct {
 	ControlplaneURL string `json:"controlplane_url"`
 	APIKey          string `json:"api_key"`
+
+	// TUFMirror is a TUF repository to fetch the Sigstore trust root from
+	// instead of the public-good instance; TUFRoot is the path of its
+	// initial root.json.
+	TUFMirror string `json:"tuf_mirror,omitempty"`
+	TUFRoot   string `json:"tuf_root,omitempty"`
+
+	// GitHub instance for release and attestation lookups, and a command
+	// that prints a token for it (e.g. `gh auth token`). See github.go.
+	GitHubAPIURL       string `json:"github_api_url,omitempty"`
+	GitHubURL          string `json:"github_url,omitempty"`
+	GitHubTokenCommand string `json:"github_token_command,omitempty"`
+
+	// RouterListURL lists the router pool checked by --all-routers. It
+	// defaults to the list the tinfoil-go default client picks from.
+	RouterListURL string `json:"router_list_url,omitempty"`
 }
 
 func configPath() (string, error) {
@@ -62,6 +82,12 @@ func loadConfig() (cliConfig, string, error) {
 	if v := strings.TrimSpace(os.Getenv(envAPIKey)); v != "" {
 		cfg.APIKey = v
 	}
+	if v := strings.TrimSpace(os.Getenv(envTUFMirror)); v != "" {
+		cfg.TUFMirror = v
+	}
+	if v := strings.TrimSpace(os.Getenv(envRouterListURL)); v != "" {
+		cfg.RouterListURL = v
+	}
 
 	if cfg.ControlplaneURL == "" {
 		cfg.ControlplaneURL = defaultControlplaneURL
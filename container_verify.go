@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	verifyAllContainers bool
+	verifyConcurrency   int
+)
+
+func init() {
+	containerCmd.AddCommand(containerVerifyCmd)
+	containerVerifyCmd.Flags().BoolVar(&verifyAllContainers, "all", false, "Verify every container in the organization")
+	containerVerifyCmd.Flags().IntVar(&verifyConcurrency, "concurrency", 4, "Number of containers to verify at once")
+	containerVerifyCmd.Flags().BoolVar(&modeAllowances.Debug, "allow-debug", false, allowDebugUsage)
+	containerVerifyCmd.Flags().BoolVar(&modeAllowances.NonCC, "allow-non-cc", false, allowNonCCUsage)
+	containerVerifyCmd.Flags().BoolVar(&allowNoHPKE, "allow-no-hpke", false, allowNoHPKEUsage)
+	addDebugSelector(containerVerifyCmd)
+}
+
+// containerVerification is the result of verifying one container. Skipped
+// is set instead of Record when the container cannot be verified yet.
+type containerVerification struct {
+	Container string       `json:"container"`
+	ID        string       `json:"id"`
+	Skipped   string       `json:"skipped,omitempty"`
+	Record    *auditRecord `json:"record,omitempty"`
+}
+
+var containerVerifyCmd = &cobra.Command{
+	Use:   "verify [id|name]",
+	Short: "Verify the attestation of deployed containers",
+	Long: `Verify that deployed containers run the code of their repo at the tag
+they were deployed from. Pass a container name or ID, or --all to check every
+container in the organization. Containers without a domain or repo (e.g.
+ones that are still starting) are skipped.`,
+	Args:         cobra.MaximumNArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if verifyAllContainers == (len(args) == 1) {
+			return fmt.Errorf("pass either a container name or --all")
+		}
+		if verifyConcurrency < 1 {
+			return fmt.Errorf("--concurrency must be at least 1")
+		}
+		client, err := authedClient()
+		if err != nil {
+			return err
+		}
+
+		var list []containerView
+		if verifyAllContainers {
+			if _, err := client.do("GET", "/api/containers", nil, nil, &list); err != nil {
+				return err
+			}
+		} else {
+			c, err := resolveContainer(client, args[0])
+			if err != nil {
+				return err
+			}
+			list = []containerView{*c}
+		}
+
+		logger := log.New()
+		logger.SetOutput(io.Discard)
+		if verbose || trace {
+			logger.SetOutput(os.Stderr)
+			logger.SetLevel(log.DebugLevel)
+		}
+
+		results := verifyContainers(logger, list, verifyConcurrency)
+		if outputFormat == "json" {
+			if err := printJSON(results); err != nil {
+				return err
+			}
+		} else {
+			renderContainerVerifications(results)
+		}
+
+		return containerVerifyError(results)
+	},
+}
+
+// containerVerifyError summarizes failed container verifications. It wraps
+// the first failure so the exit code reflects its reason.
+func containerVerifyError(results []containerVerification) error {
+	var first error
+	failed := 0
+	for _, r := range results {
+		if r.Record == nil {
+			continue
+		}
+		if err := r.Record.err(); err != nil {
+			failed++
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	if failed == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d containers failed verification: %w", failed, len(results), first)
+}
+
+// containerVerifyOptions returns what to verify a container against: its
+// public domain (or internal one), its repo and the tag it runs. The reason
+// is non-empty when the container cannot be verified.
+func containerVerifyOptions(c containerView) (verifyOptions, string) {
+	host := strings.TrimSpace(c.Domain)
+	if host == "" {
+		host = strings.TrimSpace(c.InternalDomain)
+	}
+	if host == "" {
+		return verifyOptions{}, fmt.Sprintf("no domain (status=%s)", c.Status)
+	}
+	if c.Repo == "" {
+		return verifyOptions{}, "no repo recorded"
+	}
+	return verifyOptions{Host: host, Repo: c.Repo, Tag: c.CurrentTag, Deployment: deploymentFlagsOf(c)}, ""
+}
+
+// verifyContainers verifies list with at most concurrency verifications in
+// flight and returns the results in the order of list.
+func verifyContainers(l *log.Logger, list []containerView, concurrency int) []containerVerification {
+	results := make([]containerVerification, len(list))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, c := range list {
+		results[i] = containerVerification{Container: c.Name, ID: c.ID}
+		opts, skip := containerVerifyOptions(c)
+		if skip != "" {
+			results[i].Skipped = skip
+			continue
+		}
+		wg.Add(1)
+		go func(i int, opts verifyOptions) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i].Record, _ = verifyAttestation(l, opts)
+		}(i, opts)
+	}
+	wg.Wait()
+	return results
+}
+
+func renderContainerVerifications(results []containerVerification) {
+	if len(results) == 0 {
+		fmt.Println("No containers.")
+		return
+	}
+	fmt.Printf("%-24s  %-30s  %-10s  %-12s  %s\n", "NAME", "DOMAIN", "TAG", "STATUS", "DETAIL")
+	for _, r := range results {
+		if r.Record == nil {
+			fmt.Printf("%-24s  %-30s  %-10s  %-12s  %s\n", truncate(r.Container, 24), "-", "-", "skipped", r.Skipped)
+			continue
+		}
+		tag := r.Record.Tag
+		if tag == "" {
+			tag = "-"
+		}
+		detail := r.Record.Reason
+		if r.Record.Error != "" {
+			detail = r.Record.Error
+		}
+		fmt.Printf("%-24s  %-30s  %-10s  %-12s  %s\n",
+			truncate(r.Container, 24), truncate(r.Record.Enclave, 30), truncate(tag, 10), r.Record.Status, detail,
+		)
+	}
+}
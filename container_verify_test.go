@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContainerVerifyOptions(t *testing.T) {
+	opts, skip := containerVerifyOptions(containerView{
+		Name:           "app",
+		Repo:           "acme/app",
+		CurrentTag:     "v1.0.0",
+		InternalDomain: "app.internal.tinfoil.sh",
+	})
+	assert.Empty(t, skip)
+	assert.Equal(t, verifyOptions{
+		Host:       "app.internal.tinfoil.sh",
+		Repo:       "acme/app",
+		Tag:        "v1.0.0",
+		Deployment: &deploymentFlags{Container: "app"},
+	}, opts)
+
+	opts, _ = containerVerifyOptions(containerView{
+		Repo:           "acme/app",
+		Domain:         "app.example.com",
+		InternalDomain: "app.internal.tinfoil.sh",
+	})
+	assert.Equal(t, "app.example.com", opts.Host)
+
+	_, skip = containerVerifyOptions(containerView{Repo: "acme/app", Status: "starting"})
+	assert.Equal(t, "no domain (status=starting)", skip)
+
+	_, skip = containerVerifyOptions(containerView{Domain: "app.example.com"})
+	assert.Equal(t, "no repo recorded", skip)
+}
+
+func TestContainerVerifyErrorKeepsReason(t *testing.T) {
+	ok := &auditRecord{Status: statusOK}
+	bad := newAuditRecord("app.example.com")
+	bad.fail(reasonKeyMismatch, "keys differ")
+
+	assert.NoError(t, containerVerifyError([]containerVerification{{Record: ok}, {Skipped: "no repo recorded"}}))
+
+	err := containerVerifyError([]containerVerification{{Record: ok}, {Record: bad}})
+	assert.ErrorContains(t, err, "1 of 2 containers failed verification")
+	assert.Equal(t, exitKeyMismatch, exitCode(err))
+}
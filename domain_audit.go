@@ -0,0 +1,207 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/tinfoilsh/tinfoil-go/verifier/attestation"
+)
+
+func init() {
+	domainCmd.AddCommand(domainAuditCmd)
+	domainAuditCmd.Flags().BoolVar(&modeAllowances.Debug, "allow-debug", false, allowDebugUsage)
+	domainAuditCmd.Flags().BoolVar(&modeAllowances.NonCC, "allow-non-cc", false, allowNonCCUsage)
+	domainAuditCmd.Flags().BoolVar(&allowNoHPKE, "allow-no-hpke", false, allowNoHPKEUsage)
+}
+
+// domainAudit is the result of auditing one custom domain: the containers
+// that use it, each verified on its internal domain, and the key served on
+// the custom domain itself.
+type domainAudit struct {
+	Domain     string                  `json:"domain"`
+	ServedKey  string                  `json:"served_key,omitempty"`
+	Status     string                  `json:"status,omitempty"`
+	Reason     string                  `json:"reason,omitempty"`
+	Error      string                  `json:"error,omitempty"`
+	Skipped    string                  `json:"skipped,omitempty"`
+	Containers []containerVerification `json:"containers"`
+}
+
+func (a *domainAudit) fail(reason, msg string) {
+	a.Status = statusForReason(reason)
+	a.Reason = reason
+	a.Error = msg
+}
+
+var domainAuditCmd = &cobra.Command{
+	Use:   "audit [domain]",
+	Short: "Check that custom domains serve the attested enclave key",
+	Long: `For each custom domain (or the one given), verify the attestation of every
+container that uses it on the container's internal domain, then check that
+the certificate presented on the custom domain carries the public key
+attested by one of those containers.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := authedClient()
+		if err != nil {
+			return err
+		}
+		var domains []domainView
+		if _, err := client.do("GET", "/api/domains", nil, nil, &domains); err != nil {
+			return err
+		}
+		if len(args) == 1 {
+			i := slices.IndexFunc(domains, func(d domainView) bool { return strings.EqualFold(d.Domain, args[0]) })
+			if i < 0 {
+				return fmt.Errorf("domain %s not found", args[0])
+			}
+			domains = domains[i : i+1]
+		}
+		var containers []containerView
+		if _, err := client.do("GET", "/api/containers", nil, nil, &containers); err != nil {
+			return err
+		}
+
+		logger := log.New()
+		logger.SetOutput(io.Discard)
+		if verbose || trace {
+			logger.SetOutput(os.Stderr)
+			logger.SetLevel(log.DebugLevel)
+		}
+
+		audits := make([]*domainAudit, 0, len(domains))
+		for _, d := range domains {
+			audits = append(audits, auditDomain(logger, d.Domain, domainContainers(d, containers)))
+		}
+		if outputFormat == "json" {
+			if err := printJSON(audits); err != nil {
+				return err
+			}
+		} else {
+			renderDomainAudits(audits)
+		}
+		return domainAuditError(audits)
+	},
+}
+
+// domainContainers returns the containers that serve d, either because it
+// is their domain or because the controlplane lists them as users of it.
+func domainContainers(d domainView, containers []containerView) []containerView {
+	var users []containerView
+	for _, c := range containers {
+		if strings.EqualFold(strings.TrimSpace(c.Domain), d.Domain) ||
+			slices.Contains(d.UsedBy, c.Name) || slices.Contains(d.UsedBy, c.ID) {
+			users = append(users, c)
+		}
+	}
+	return users
+}
+
+// auditDomain verifies containers on their internal domains and compares
+// their attested keys with the key served on domain.
+func auditDomain(l *log.Logger, domain string, containers []containerView) *domainAudit {
+	audit := &domainAudit{Domain: domain, Containers: []containerVerification{}}
+	if len(containers) == 0 {
+		audit.Skipped = "no container uses this domain"
+		return audit
+	}
+
+	for _, c := range containers {
+		cv := containerVerification{Container: c.Name, ID: c.ID}
+		opts, skip := containerVerifyOptions(c)
+		switch {
+		case skip != "":
+			cv.Skipped = skip
+		case strings.TrimSpace(c.InternalDomain) == "":
+			cv.Skipped = "no internal domain"
+		default:
+			opts.Host = strings.TrimSpace(c.InternalDomain)
+			cv.Record, _ = verifyAttestation(l, opts)
+		}
+		audit.Containers = append(audit.Containers, cv)
+	}
+
+	cs, err := tlsConnection(enclaveAddr(domain))
+	if err != nil {
+		audit.fail(reasonFetchError, fmt.Sprintf("connecting to %s: %v", domain, err))
+		return audit
+	}
+	audit.ServedKey, err = attestation.ConnectionCertFP(tls.ConnectionState{PeerCertificates: cs.PeerCertificates})
+	if err != nil {
+		audit.fail(reasonFetchError, fmt.Sprintf("computing certificate fingerprint: %v", err))
+		return audit
+	}
+	checkServedKey(audit)
+	return audit
+}
+
+// checkServedKey sets the audit's status from its served key and container
+// verifications. The domain passes when the served key is the attested key
+// of a container that verified.
+func checkServedKey(audit *domainAudit) {
+	for _, cv := range audit.Containers {
+		if cv.Record == nil || cv.Record.Keys.Enclave != audit.ServedKey {
+			continue
+		}
+		if cv.Record.Status == statusOK {
+			audit.Status = statusOK
+			return
+		}
+		audit.fail(cv.Record.Reason, fmt.Sprintf("%s serves the key of container %s, which failed verification: %s",
+			audit.Domain, cv.Container, cv.Record.Error))
+		return
+	}
+	audit.fail(reasonKeyMismatch, fmt.Sprintf("%s serves key %s, which is not attested by any container using the domain",
+		audit.Domain, audit.ServedKey))
+}
+
+// domainAuditError summarizes failed audits, wrapping the first failure so
+// the exit code reflects its reason.
+func domainAuditError(audits []*domainAudit) error {
+	var first error
+	failed := 0
+	for _, a := range audits {
+		if a.Reason != "" {
+			failed++
+			if first == nil {
+				first = &verificationError{Reason: a.Reason, Err: fmt.Errorf("%s", a.Error)}
+			}
+		}
+	}
+	if failed == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d domains failed the audit: %w", failed, len(audits), first)
+}
+
+func renderDomainAudits(audits []*domainAudit) {
+	if len(audits) == 0 {
+		fmt.Println("No custom domains.")
+		return
+	}
+	fmt.Printf("%-40s  %-24s  %-12s  %s\n", "DOMAIN", "CONTAINER", "STATUS", "DETAIL")
+	for _, a := range audits {
+		status, detail := a.Status, a.Error
+		if a.Skipped != "" {
+			status, detail = "skipped", a.Skipped
+		}
+		if detail == "" && a.ServedKey != "" {
+			detail = "serves attested key " + truncate(a.ServedKey, 16)
+		}
+		fmt.Printf("%-40s  %-24s  %-12s  %s\n", truncate(a.Domain, 40), "-", status, detail)
+		for _, cv := range a.Containers {
+			status, detail := "skipped", cv.Skipped
+			if cv.Record != nil {
+				status, detail = cv.Record.Status, cv.Record.Error
+			}
+			fmt.Printf("%-40s  %-24s  %-12s  %s\n", "", truncate(cv.Container, 24), status, detail)
+		}
+	}
+}
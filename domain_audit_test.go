@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDomainContainers(t *testing.T) {
+	containers := []containerView{
+		{ID: "1", Name: "api", Domain: "API.example.com"},
+		{ID: "2", Name: "api-canary"},
+		{ID: "3", Name: "other", Domain: "other.example.com"},
+	}
+	users := domainContainers(domainView{Domain: "api.example.com", UsedBy: []string{"api-canary"}}, containers)
+	assert.Len(t, users, 2)
+	assert.Equal(t, "api", users[0].Name)
+	assert.Equal(t, "api-canary", users[1].Name)
+}
+
+func TestCheckServedKey(t *testing.T) {
+	verified := newAuditRecord("api.internal.tinfoil.sh")
+	verified.Status = statusOK
+	verified.Keys.Enclave = "key-a"
+	mismatched := newAuditRecord("canary.internal.tinfoil.sh")
+	mismatched.Keys.Enclave = "key-b"
+	mismatched.fail(reasonMeasurementMismatch, "PCR register mismatch")
+
+	audit := func(served string) *domainAudit {
+		a := &domainAudit{Domain: "api.example.com", ServedKey: served, Containers: []containerVerification{
+			{Container: "api", Record: verified},
+			{Container: "api-canary", Record: mismatched},
+			{Container: "starting", Skipped: "no domain (status=starting)"},
+		}}
+		checkServedKey(a)
+		return a
+	}
+
+	assert.Equal(t, statusOK, audit("key-a").Status)
+
+	a := audit("key-b")
+	assert.Equal(t, statusFail, a.Status)
+	assert.Equal(t, reasonMeasurementMismatch, a.Reason)
+	assert.Contains(t, a.Error, "api-canary")
+
+	a = audit("key-c")
+	assert.Equal(t, reasonKeyMismatch, a.Reason)
+
+	err := domainAuditError([]*domainAudit{audit("key-a"), a})
+	assert.EqualError(t, err, "1 of 2 domains failed the audit: "+a.Error)
+	assert.Equal(t, exitKeyMismatch, exitCode(err))
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tinfoilsh/tinfoil-go/verifier/attestation"
+)
+
+// attestationPath is where an enclave serves its attestation document.
+const attestationPath = "/.well-known/tinfoil-attestation"
+
+const dialTimeout = 30 * time.Second
+
+// verifierTransport is shared by every HTTP fetch made while verifying
+// (GitHub, the enclave and Rekor), so they all go through the proxy given
+// by HTTPS_PROXY/HTTP_PROXY/NO_PROXY.
+var verifierTransport = func() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = http.ProxyFromEnvironment
+	return t
+}()
+
+var enclaveHTTP = &http.Client{Timeout: 30 * time.Second, Transport: verifierTransport}
+
+// enclaveAddr returns host as a host:port address, defaulting to port 443
+// when host has none.
+func enclaveAddr(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), "443")
+}
+
+// tlsConnection performs a TLS handshake with addr (host:port), tunnelling
+// through the environment's HTTPS proxy if one applies, and returns the
+// resulting connection state. The connection is closed before returning.
+func tlsConnection(addr string) (*tls.ConnectionState, error) {
+	conn, err := dialEnclave(addr, http.ProxyFromEnvironment)
+	if err != nil {
+		return nil, fmt.Errorf("dialing enclave: %v", err)
+	}
+	defer conn.Close()
+
+	host, _, _ := net.SplitHostPort(addr)
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: host})
+	tlsConn.SetDeadline(time.Now().Add(dialTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, fmt.Errorf("dialing enclave: %v", err)
+	}
+	cs := tlsConn.ConnectionState()
+	return &cs, nil
+}
+
+// dialEnclave opens a TCP connection to addr, either directly or through an
+// HTTP CONNECT tunnel when proxy selects a proxy for it.
+func dialEnclave(addr string, proxy func(*http.Request) (*url.URL, error)) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	proxyURL, err := proxy(&http.Request{URL: &url.URL{Scheme: "https", Host: addr}})
+	if err != nil {
+		return nil, fmt.Errorf("resolving proxy: %w", err)
+	}
+	if proxyURL == nil {
+		return dialer.Dial("tcp", addr)
+	}
+
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		port := "80"
+		if proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), port)
+	}
+	conn, err := dialer.Dial("tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("dialing proxy %s: %w", proxyURL.Redacted(), err)
+	}
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("connecting to proxy %s: %w", proxyURL.Redacted(), err)
+		}
+		conn = tlsConn
+	}
+
+	if err := connectTunnel(conn, addr, proxyURL); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: %w", proxyURL.Redacted(), err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// connectTunnel asks the proxy on conn to open a tunnel to addr.
+func connectTunnel(conn net.Conn, addr string, proxyURL *url.URL) error {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if u := proxyURL.User; u != nil {
+		password, _ := u.Password()
+		creds := base64.StdEncoding.EncodeToString([]byte(u.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+creds)
+	}
+	if err := req.Write(conn); err != nil {
+		return fmt.Errorf("sending CONNECT: %w", err)
+	}
+
+	// The proxy sends nothing after its response until the tunnel is used,
+	// so reading through a bufio.Reader cannot swallow TLS bytes.
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return fmt.Errorf("reading CONNECT response: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("CONNECT %s: %s", addr, resp.Status)
+	}
+	return nil
+}
+
+// fetchAttestationDocument downloads the enclave's attestation document
+// over the shared verifier transport.
+func fetchAttestationDocument(host string) (*attestation.Document, error) {
+	resp, err := enclaveHTTP.Get("https://" + host + attestationPath)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", attestationPath, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", attestationPath, resp.Status)
+	}
+	var doc attestation.Document
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("decoding attestation document: %w", err)
+	}
+	return &doc, nil
+}
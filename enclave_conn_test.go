@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnclaveAddr(t *testing.T) {
+	assert.Equal(t, "enclave.example.com:443", enclaveAddr("enclave.example.com"))
+	assert.Equal(t, "enclave.example.com:8443", enclaveAddr("enclave.example.com:8443"))
+	assert.Equal(t, "[::1]:443", enclaveAddr("::1"))
+	assert.Equal(t, "[::1]:443", enclaveAddr("[::1]"))
+	assert.Equal(t, "[::1]:8443", enclaveAddr("[::1]:8443"))
+}
+
+// connectProxy is a minimal HTTP CONNECT proxy that records the tunnels it
+// opened and the Proxy-Authorization header it was sent.
+type connectProxy struct {
+	targets []string
+	auth    string
+}
+
+func (p *connectProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect {
+		http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+		return
+	}
+	p.targets = append(p.targets, r.Host)
+	p.auth = r.Header.Get("Proxy-Authorization")
+	upstream, err := net.Dial("tcp", r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	client, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	io.WriteString(client, "HTTP/1.1 200 Connection established\r\n\r\n")
+	go func() {
+		io.Copy(upstream, client)
+		upstream.Close()
+	}()
+	io.Copy(client, upstream)
+	client.Close()
+}
+
+func TestDialEnclaveThroughProxy(t *testing.T) {
+	enclave := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer enclave.Close()
+	proxy := &connectProxy{}
+	proxySrv := httptest.NewServer(proxy)
+	defer proxySrv.Close()
+
+	proxyURL, err := url.Parse(proxySrv.URL)
+	require.NoError(t, err)
+	proxyURL.User = url.UserPassword("alice", "secret")
+	addr := enclave.Listener.Addr().String()
+
+	conn, err := dialEnclave(addr, http.ProxyURL(proxyURL))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(enclave.Certificate())
+	tlsConn := tls.Client(conn, &tls.Config{RootCAs: roots, ServerName: "example.com"})
+	require.NoError(t, tlsConn.Handshake())
+	assert.Equal(t, enclave.Certificate().Raw, tlsConn.ConnectionState().PeerCertificates[0].Raw)
+	assert.Equal(t, []string{addr}, proxy.targets)
+	assert.Equal(t, "Basic YWxpY2U6c2VjcmV0", proxy.auth)
+}
+
+func TestDialEnclaveProxyRefused(t *testing.T) {
+	proxySrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "denied", http.StatusForbidden)
+	}))
+	defer proxySrv.Close()
+	proxyURL, err := url.Parse(proxySrv.URL)
+	require.NoError(t, err)
+
+	_, err = dialEnclave("enclave.example.com:443", http.ProxyURL(proxyURL))
+	assert.ErrorContains(t, err, "403")
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/tinfoilsh/tinfoil-go/verifier/attestation"
+	"github.com/tinfoilsh/tinfoil-go/verifier/client"
+)
+
+// modeAllowances back the --allow-debug and --allow-non-cc flags.
+var modeAllowances struct {
+	Debug bool
+	NonCC bool
+}
+
+const (
+	allowDebugUsage = "Accept an enclave running in debug or staging mode"
+	allowNonCCUsage = "Accept an enclave running with confidential computing disabled"
+)
+
+// Sources of enclaveMode.
+const (
+	modeSourceAttestation  = "attestation"
+	modeSourceControlplane = "controlplane"
+)
+
+// enclaveMode describes whether an enclave runs in a mode that weakens its
+// guarantees: debug (the host can inspect the guest, or SSH is enabled),
+// staging, or with confidential computing disabled. Sources lists where the
+// mode was read from; the controlplane is only consulted when an API key
+// is configured.
+type enclaveMode struct {
+	Debug   bool     `json:"debug"`
+	Staging bool     `json:"staging"`
+	NonCC   bool     `json:"non_cc"`
+	Sources []string `json:"sources"`
+}
+
+// deploymentFlags are the mode settings of a deployed container, as
+// recorded by the controlplane.
+type deploymentFlags struct {
+	Container     string
+	Debug         bool
+	Staging       bool
+	DisableCCMode bool
+}
+
+func deploymentFlagsOf(c containerView) *deploymentFlags {
+	return &deploymentFlags{
+		Container:     c.Name,
+		Debug:         c.Debug,
+		Staging:       c.Staging,
+		DisableCCMode: c.DisableCCMode,
+	}
+}
+
+// detectEnclaveMode combines what the attestation says about the enclave
+// with its controlplane record. platform and deployment may be nil.
+func detectEnclaveMode(format attestation.PredicateType, platform *platformReport, deployment *deploymentFlags) *enclaveMode {
+	m := &enclaveMode{Sources: []string{modeSourceAttestation}}
+	switch platformName(format) {
+	case "sev-snp", "tdx", "nitro":
+	default:
+		m.NonCC = true
+	}
+	if platform != nil && platform.Debug {
+		m.Debug = true
+	}
+	if deployment != nil {
+		m.Sources = append(m.Sources, modeSourceControlplane)
+		m.Debug = m.Debug || deployment.Debug
+		m.Staging = deployment.Staging
+		m.NonCC = m.NonCC || deployment.DisableCCMode
+	}
+	return m
+}
+
+// checkEnclaveMode returns an error if m is a mode that was not allowed
+// with --allow-debug or --allow-non-cc.
+func checkEnclaveMode(m *enclaveMode) error {
+	var modes, flags []string
+	if m.Debug && !modeAllowances.Debug {
+		modes = append(modes, "debug mode")
+	}
+	if m.Staging && !modeAllowances.Debug {
+		modes = append(modes, "staging mode")
+	}
+	if len(modes) > 0 {
+		flags = append(flags, "--allow-debug")
+	}
+	if m.NonCC && !modeAllowances.NonCC {
+		modes = append(modes, "confidential computing disabled")
+		flags = append(flags, "--allow-non-cc")
+	}
+	if len(modes) == 0 {
+		return nil
+	}
+	return fmt.Errorf("enclave runs with %s; pass %s to accept it", strings.Join(modes, " and "), strings.Join(flags, " and "))
+}
+
+// deploymentListTTL is how long the controlplane's container list is reused
+// before lookupDeployment lists the containers again.
+const deploymentListTTL = 5 * time.Minute
+
+// deploymentList caches the container list across verifications in this
+// process, so that watch, router pools and re-verifying transports do not
+// list every container on each check.
+var deploymentList struct {
+	sync.Mutex
+	containers []containerView
+	fetchedAt  time.Time
+}
+
+// lookupDeployment finds the container serving host in the organization's
+// controlplane records. It returns nil when no API key is configured or no
+// container matches; controlplane errors are logged and also return nil,
+// so that verification does not depend on the controlplane being up.
+func lookupDeployment(l *log.Logger, host string) *deploymentFlags {
+	cfg, _, err := loadConfig()
+	if err != nil || cfg.APIKey == "" {
+		return nil
+	}
+	list, err := listDeployments(cfg)
+	if err != nil {
+		l.Warnf("Could not look up %s in the controlplane: %v", host, err)
+		return nil
+	}
+	if c := findDeployment(list, host); c != nil {
+		return deploymentFlagsOf(*c)
+	}
+	return nil
+}
+
+// listDeployments returns the organization's containers, listing them from
+// the controlplane at most once per deploymentListTTL. Failures are not
+// cached.
+func listDeployments(cfg cliConfig) ([]containerView, error) {
+	deploymentList.Lock()
+	defer deploymentList.Unlock()
+	if !deploymentList.fetchedAt.IsZero() && time.Since(deploymentList.fetchedAt) < deploymentListTTL {
+		return deploymentList.containers, nil
+	}
+	var list []containerView
+	if _, err := newCPClient(cfg).do("GET", "/api/containers", nil, nil, &list); err != nil {
+		return nil, err
+	}
+	deploymentList.containers, deploymentList.fetchedAt = list, time.Now()
+	return list, nil
+}
+
+// findDeployment returns the container in list whose domain is host.
+func findDeployment(list []containerView, host string) *containerView {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for i, c := range list {
+		for _, d := range []string{c.Domain, c.InternalDomain} {
+			if d = strings.TrimSpace(d); d != "" && strings.EqualFold(d, host) {
+				return &list[i]
+			}
+		}
+	}
+	return nil
+}
+
+// useLibraryClient reports whether the http and proxy commands may use the
+// tinfoil-go client. It always compares against the latest release and
+// makes neither the enclave mode nor the known_enclaves check, so it is only
+// used when no option needs our own verification and both checks are off.
+func useLibraryClient() bool {
+	modeCheck := !modeAllowances.Debug || !modeAllowances.NonCC
+	return !customVerification() && !modeCheck && knownEnclavesMode == knownEnclavesOff
+}
+
+// pinnedVerifyOptions returns the verification options for the pinned
+// client. Without --host and --repo it verifies the router tinfoil-go would
+// select against the router's repo, as the library client does. A policy
+// names its own repos, so it needs an explicit --host.
+func pinnedVerifyOptions() (verifyOptions, error) {
+	opts := currentVerifyOptions()
+	if opts.Policy != "" && opts.Host == "" {
+		return opts, fmt.Errorf("--policy requires --host")
+	}
+	if opts.Host == "" && opts.Repo == "" {
+		router, err := client.NewDefaultClient()
+		if err != nil {
+			return opts, verifyErrorf(reasonFetchError, "getting router: %v", err)
+		}
+		opts.Host, opts.Repo = router.Enclave(), router.Repo()
+	}
+	return opts, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tinfoilsh/tinfoil-go/verifier/attestation"
+)
+
+func TestDetectEnclaveMode(t *testing.T) {
+	snp := attestation.PredicateType("https://tinfoil.sh/predicate/sev-snp-guest/v2")
+
+	m := detectEnclaveMode(snp, &platformReport{Platform: "sev-snp"}, nil)
+	assert.Equal(t, &enclaveMode{Sources: []string{modeSourceAttestation}}, m)
+
+	m = detectEnclaveMode(snp, &platformReport{Platform: "sev-snp", Debug: true}, nil)
+	assert.True(t, m.Debug)
+
+	m = detectEnclaveMode(snp, nil, &deploymentFlags{Staging: true, DisableCCMode: true})
+	assert.Equal(t, &enclaveMode{
+		Staging: true,
+		NonCC:   true,
+		Sources: []string{modeSourceAttestation, modeSourceControlplane},
+	}, m)
+
+	m = detectEnclaveMode(attestation.PredicateType("https://example.com/predicate/none"), nil, nil)
+	assert.True(t, m.NonCC)
+}
+
+func TestCheckEnclaveMode(t *testing.T) {
+	defer func(a struct{ Debug, NonCC bool }) { modeAllowances = a }(modeAllowances)
+	modeAllowances.Debug, modeAllowances.NonCC = false, false
+
+	assert.NoError(t, checkEnclaveMode(&enclaveMode{}))
+	assert.EqualError(t, checkEnclaveMode(&enclaveMode{Debug: true, Staging: true}),
+		"enclave runs with debug mode and staging mode; pass --allow-debug to accept it")
+	assert.EqualError(t, checkEnclaveMode(&enclaveMode{Staging: true, NonCC: true}),
+		"enclave runs with staging mode and confidential computing disabled; pass --allow-debug and --allow-non-cc to accept it")
+
+	modeAllowances.Debug = true
+	assert.NoError(t, checkEnclaveMode(&enclaveMode{Debug: true, Staging: true}))
+	assert.Error(t, checkEnclaveMode(&enclaveMode{Debug: true, NonCC: true}))
+	modeAllowances.NonCC = true
+	assert.NoError(t, checkEnclaveMode(&enclaveMode{Debug: true, NonCC: true}))
+}
+
+func TestFindDeployment(t *testing.T) {
+	list := []containerView{
+		{Name: "a", Domain: "a.example.com"},
+		{Name: "b", InternalDomain: "b.internal.tinfoil.sh"},
+	}
+	assert.Equal(t, "a", findDeployment(list, "A.example.com").Name)
+	assert.Equal(t, "b", findDeployment(list, "b.internal.tinfoil.sh:443").Name)
+	assert.Nil(t, findDeployment(list, "c.example.com"))
+}
+
+func TestLookupDeploymentReusesContainerList(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		json.NewEncoder(w).Encode([]containerView{{Name: "a", Domain: "a.example.com", Debug: true}})
+	}))
+	defer srv.Close()
+	t.Setenv(envConfigPath, filepath.Join(t.TempDir(), "config.json"))
+	t.Setenv(envAPIKey, "test-key")
+	t.Setenv(envCPURL, srv.URL)
+	t.Cleanup(func() { deploymentList.fetchedAt = time.Time{} })
+
+	d := lookupDeployment(discardLogger(), "a.example.com")
+	require.NotNil(t, d)
+	assert.True(t, d.Debug)
+	assert.Nil(t, lookupDeployment(discardLogger(), "b.example.com"))
+	assert.Equal(t, 1, calls)
+}
+
+func TestPinnedVerifyOptionsPolicyRequiresHost(t *testing.T) {
+	defer func(p, h string) { policyPath, enclaveHost = p, h }(policyPath, enclaveHost)
+	policyPath, enclaveHost = "policy.yaml", ""
+
+	_, err := pinnedVerifyOptions()
+	assert.EqualError(t, err, "--policy requires --host")
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/tinfoilsh/tinfoil-go/verifier/attestation"
+)
+
+// Layout of saved evidence, either as a directory or as a .tar.gz holding
+// the same files. verify --offline reads these files; nothing else in the
+// directory or archive is consulted.
+const (
+	evidenceManifestFile    = "manifest.json"
+	evidenceAttestationFile = "attestation.json"
+	evidenceBundleFile      = "bundle.json"
+	evidenceTrustRootFile   = "trust_root.json"
+	evidenceCertsFile       = "certificates.pem"
+	evidenceHPKEKeysFile    = "hpke_keys.bin" // optional
+
+	evidenceRekorConsistencyFile = "rekor_consistency.json" // optional
+
+	evidenceManifestVersion = 1
+)
+
+// evidenceManifest describes a saved evidence directory. Files lists a
+// sha256 for every evidence file so accidental corruption is caught before
+// any of them is used. The manifest is not signed and does not protect
+// against deliberate edits; the evidence itself is verified against the
+// hardware and Sigstore roots of trust.
+type evidenceManifest struct {
+	Version    int    `json:"version"`
+	Enclave    string `json:"enclave"`
+	Repo       string `json:"repo,omitempty"`
+	Tag        string `json:"tag,omitempty"`
+	Digest     string `json:"digest,omitempty"`
+	CapturedAt string `json:"captured_at"`
+
+	TrustRootSource string `json:"trust_root_source,omitempty"`
+
+	Files []evidenceFile `json:"files"`
+}
+
+type evidenceFile struct {
+	Name      string `json:"name"`
+	SHA256    string `json:"sha256"`
+	Size      int    `json:"size"`
+	FetchedAt string `json:"fetched_at,omitempty"`
+}
+
+// loadEvidence reads saved evidence from a directory or .tar.gz archive.
+// The enclave, repo and digest come from the manifest; if opts sets them
+// they must agree, so an auditor can assert what the evidence is expected
+// to show.
+func loadEvidence(l *log.Logger, path string, opts verifyOptions) (*evidence, error) {
+	l.Printf("Loading evidence from %s", path)
+	raw, err := readEvidenceFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	manifestBytes, ok := raw[evidenceManifestFile]
+	if !ok {
+		return nil, fmt.Errorf("evidence is missing %s", evidenceManifestFile)
+	}
+	var manifest evidenceManifest
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return nil, fmt.Errorf("parsing evidence manifest: %w", err)
+	}
+	if manifest.Version != evidenceManifestVersion {
+		return nil, fmt.Errorf("unsupported evidence manifest version %d", manifest.Version)
+	}
+
+	files := map[string][]byte{}
+	for _, f := range manifest.Files {
+		if f.Name != filepath.Base(f.Name) || f.Name == evidenceManifestFile {
+			return nil, fmt.Errorf("evidence manifest lists invalid file name %q", f.Name)
+		}
+		data, ok := raw[f.Name]
+		if !ok {
+			return nil, fmt.Errorf("evidence manifest lists %s but it is missing", f.Name)
+		}
+		if sha256Hex(data) != f.SHA256 {
+			return nil, fmt.Errorf("evidence file %s does not match the sha256 in the manifest", f.Name)
+		}
+		files[f.Name] = data
+	}
+
+	ev := &evidence{
+		Host:   manifest.Enclave,
+		Repo:   manifest.Repo,
+		Tag:    manifest.Tag,
+		Digest: manifest.Digest,
+	}
+	if err := checkEvidenceExpectation("enclave", opts.Host, ev.Host); err != nil {
+		return nil, err
+	}
+	if err := checkEvidenceExpectation("repo", opts.Repo, ev.Repo); err != nil {
+		return nil, err
+	}
+	if err := checkEvidenceExpectation("tag", opts.Tag, ev.Tag); err != nil {
+		return nil, err
+	}
+	if opts.Digest != "" {
+		d, err := normalizeDigest(opts.Digest)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkEvidenceExpectation("digest", d, ev.Digest); err != nil {
+			return nil, err
+		}
+	}
+
+	docBytes, ok := files[evidenceAttestationFile]
+	if !ok {
+		return nil, fmt.Errorf("evidence is missing %s", evidenceAttestationFile)
+	}
+	var doc attestation.Document
+	if err := json.Unmarshal(docBytes, &doc); err != nil {
+		return nil, fmt.Errorf("parsing attestation document: %w", err)
+	}
+	ev.Attestation = &doc
+
+	certBytes, ok := files[evidenceCertsFile]
+	if !ok {
+		return nil, fmt.Errorf("evidence is missing %s", evidenceCertsFile)
+	}
+	ev.PeerCerts, err = parseCertificateChain(certBytes)
+	if err != nil {
+		return nil, err
+	}
+	capturedAt, err := time.Parse(time.RFC3339, manifest.CapturedAt)
+	if err != nil {
+		return nil, fmt.Errorf("parsing evidence capture time: %w", err)
+	}
+	if err := verifyCertificateChain(ev.PeerCerts, ev.Host, capturedAt); err != nil {
+		return nil, err
+	}
+
+	ev.HPKEKeys = files[evidenceHPKEKeysFile]
+
+	if ev.Repo != "" {
+		if ev.Digest == "" {
+			return nil, fmt.Errorf("evidence manifest has a repo but no digest")
+		}
+		if ev.Bundle, ok = files[evidenceBundleFile]; !ok {
+			return nil, fmt.Errorf("evidence is missing %s", evidenceBundleFile)
+		}
+		if ev.TrustRoot, ok = files[evidenceTrustRootFile]; !ok {
+			return nil, fmt.Errorf("evidence is missing %s", evidenceTrustRootFile)
+		}
+		ev.TrustRootSource = "evidence"
+		if manifest.TrustRootSource != "" {
+			ev.TrustRootSource = "evidence (" + manifest.TrustRootSource + ")"
+		}
+		if data, ok := files[evidenceRekorConsistencyFile]; ok {
+			ev.RekorConsistency = &consistencyProof{}
+			if err := json.Unmarshal(data, ev.RekorConsistency); err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", evidenceRekorConsistencyFile, err)
+			}
+		}
+	}
+
+	return ev, nil
+}
+
+func checkEvidenceExpectation(field, want, got string) error {
+	if want != "" && want != got {
+		return fmt.Errorf("evidence %s is %q, expected %q", field, got, want)
+	}
+	return nil
+}
+
+func parseCertificateChain(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing certificate chain: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("certificate chain is empty")
+	}
+	return certs, nil
+}
+
+// evidenceRoots are the roots saved certificate chains must chain to. Nil
+// means the system roots; tests replace it.
+var evidenceRoots *x509.CertPool
+
+// verifyCertificateChain repeats the check tls.Dial performed when the
+// chain was captured: the leaf must be valid for host, without any port, and
+// chain to a system root at the time of capture.
+func verifyCertificateChain(certs []*x509.Certificate, host string, at time.Time) error {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		DNSName:       host,
+		Roots:         evidenceRoots,
+		Intermediates: intermediates,
+		CurrentTime:   at,
+	})
+	if err != nil {
+		return fmt.Errorf("verifying certificate chain: %w", err)
+	}
+	return nil
+}
+
+// saveEvidence writes ev to path as a directory or, if path ends in .tar.gz
+// or .tgz, as a gzipped tarball. The result can be replayed with
+// `attestation verify --offline`.
+func saveEvidence(ev *evidence, path string, capturedAt time.Time) (*evidenceManifest, error) {
+	docBytes, err := json.MarshalIndent(ev.Attestation, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encoding attestation document: %w", err)
+	}
+	var certs bytes.Buffer
+	for _, c := range ev.PeerCerts {
+		if err := pem.Encode(&certs, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw}); err != nil {
+			return nil, fmt.Errorf("encoding certificate chain: %w", err)
+		}
+	}
+
+	files := map[string][]byte{
+		evidenceAttestationFile: docBytes,
+		evidenceCertsFile:       certs.Bytes(),
+	}
+	if ev.HPKEKeys != nil {
+		files[evidenceHPKEKeysFile] = ev.HPKEKeys
+	}
+	if ev.Repo != "" {
+		files[evidenceBundleFile] = ev.Bundle
+		files[evidenceTrustRootFile] = ev.TrustRoot
+	}
+	if ev.RekorConsistency != nil {
+		consistency, err := json.Marshal(ev.RekorConsistency)
+		if err != nil {
+			return nil, fmt.Errorf("encoding rekor consistency proof: %w", err)
+		}
+		files[evidenceRekorConsistencyFile] = consistency
+	}
+
+	manifest := &evidenceManifest{
+		Version:    evidenceManifestVersion,
+		Enclave:    ev.Host,
+		Repo:       ev.Repo,
+		Tag:        ev.Tag,
+		Digest:     ev.Digest,
+		CapturedAt: capturedAt.UTC().Format(time.RFC3339),
+
+		TrustRootSource: ev.TrustRootSource,
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := evidenceFile{
+			Name:   name,
+			SHA256: sha256Hex(files[name]),
+			Size:   len(files[name]),
+		}
+		if at, ok := ev.FetchedAt[name]; ok {
+			f.FetchedAt = at.UTC().Format(time.RFC3339Nano)
+		}
+		manifest.Files = append(manifest.Files, f)
+	}
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encoding evidence manifest: %w", err)
+	}
+	files[evidenceManifestFile] = append(manifestBytes, '\n')
+
+	if isTarball(path) {
+		return manifest, writeEvidenceTarball(path, files)
+	}
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return nil, fmt.Errorf("creating %s: %w", path, err)
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(path, name), data, 0o644); err != nil {
+			return nil, fmt.Errorf("writing evidence: %w", err)
+		}
+	}
+	return manifest, nil
+}
+
+func isTarball(path string) bool {
+	return strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz")
+}
+
+func writeEvidenceTarball(path string, files map[string][]byte) error {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    0o644,
+			Size:    int64(len(files[name])),
+			ModTime: time.Now(),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("writing evidence archive: %w", err)
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			return fmt.Errorf("writing evidence archive: %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("writing evidence archive: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("writing evidence archive: %w", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return nil
+}
+
+// readEvidenceFiles returns the evidence files in a directory or .tar.gz
+// archive, keyed by file name.
+func readEvidenceFiles(path string) (map[string][]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading evidence: %w", err)
+	}
+
+	files := map[string][]byte{}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading evidence: %w", err)
+		}
+		for _, e := range entries {
+			if !e.Type().IsRegular() {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(path, e.Name()))
+			if err != nil {
+				return nil, fmt.Errorf("reading evidence: %w", err)
+			}
+			files[e.Name()] = data
+		}
+		return files, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading evidence: %w", err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading evidence archive: %w", err)
+	}
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading evidence archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("reading evidence archive: %w", err)
+		}
+		files[filepath.Base(hdr.Name)] = data
+	}
+	return files, nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tinfoilsh/tinfoil-go/verifier/attestation"
+)
+
+func discardLogger() *log.Logger {
+	l := log.New()
+	l.SetOutput(io.Discard)
+	return l
+}
+
+func writeTestEvidence(t *testing.T, manifest evidenceManifest, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+		sum := sha256.Sum256([]byte(content))
+		manifest.Files = append(manifest.Files, evidenceFile{Name: name, SHA256: hex.EncodeToString(sum[:]), Size: len(content)})
+	}
+	data, err := json.Marshal(manifest)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, evidenceManifestFile), data, 0o644))
+	return dir
+}
+
+func testManifest() evidenceManifest {
+	return evidenceManifest{
+		Version:    evidenceManifestVersion,
+		Enclave:    "inference.tinfoil.sh",
+		Repo:       "tinfoilsh/confidential-model-router",
+		Digest:     "0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f",
+		CapturedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// testCertificateChain returns a PEM leaf certificate for host and installs
+// its self-signed issuer as the only evidence root for the test.
+func testCertificateChain(t *testing.T, host string) string {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	ca, err = x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	leafDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, &leafKey.PublicKey, caKey)
+	require.NoError(t, err)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	evidenceRoots = roots
+	t.Cleanup(func() { evidenceRoots = nil })
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER}))
+}
+
+func TestLoadEvidenceDetectsTampering(t *testing.T) {
+	dir := writeTestEvidence(t, testManifest(), map[string]string{
+		evidenceAttestationFile: `{"format":"x","body":"y"}`,
+	})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, evidenceAttestationFile), []byte(`{"format":"x","body":"z"}`), 0o644))
+
+	_, err := loadEvidence(discardLogger(), dir, verifyOptions{})
+	assert.ErrorContains(t, err, "does not match the sha256")
+}
+
+func TestLoadEvidenceChecksExpectations(t *testing.T) {
+	dir := writeTestEvidence(t, testManifest(), map[string]string{
+		evidenceAttestationFile: `{"format":"x","body":"y"}`,
+	})
+
+	_, err := loadEvidence(discardLogger(), dir, verifyOptions{Repo: "someone/else"})
+	assert.ErrorContains(t, err, "evidence repo")
+
+	_, err = loadEvidence(discardLogger(), dir, verifyOptions{Digest: "sha256:" + "ab" + testManifest().Digest[2:]})
+	assert.ErrorContains(t, err, "evidence digest")
+}
+
+func TestLoadEvidenceRejectsPathsInManifest(t *testing.T) {
+	m := testManifest()
+	m.Files = []evidenceFile{{Name: "../outside.json"}}
+	dir := writeTestEvidence(t, m, nil)
+
+	_, err := loadEvidence(discardLogger(), dir, verifyOptions{})
+	assert.ErrorContains(t, err, "invalid file name")
+}
+
+func TestSaveEvidenceRoundTrip(t *testing.T) {
+	for _, name := range []string{"evidence", "evidence.tar.gz"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			ev := &evidence{
+				Host:        "inference.tinfoil.sh",
+				Repo:        "tinfoilsh/confidential-model-router",
+				Tag:         "v1.0.0",
+				Digest:      testManifest().Digest,
+				Bundle:      []byte(`{"bundle":true}`),
+				TrustRoot:   []byte(`{"trust_root":true}`),
+				Attestation: &attestation.Document{Format: "x", Body: "y"},
+				HPKEKeys:    []byte{0x01, 0x00, 0x20},
+				FetchedAt:   map[string]time.Time{evidenceBundleFile: time.Now()},
+			}
+			manifest, err := saveEvidence(ev, path, time.Now())
+			require.NoError(t, err)
+			assert.Len(t, manifest.Files, 5)
+
+			raw, err := readEvidenceFiles(path)
+			require.NoError(t, err)
+			assert.Equal(t, ev.Bundle, raw[evidenceBundleFile])
+			assert.Equal(t, ev.TrustRoot, raw[evidenceTrustRootFile])
+			assert.Equal(t, ev.HPKEKeys, raw[evidenceHPKEKeysFile])
+			assert.Contains(t, raw, evidenceManifestFile)
+
+			// The chain is empty, so loading stops at the certificate check
+			// after the hashes and manifest have been accepted.
+			_, err = loadEvidence(discardLogger(), path, verifyOptions{Repo: ev.Repo, Tag: ev.Tag})
+			assert.ErrorContains(t, err, "certificate chain is empty")
+		})
+	}
+}
+
+func TestLoadEvidenceIgnoresPortInHost(t *testing.T) {
+	m := testManifest()
+	m.Enclave = "inference.tinfoil.sh:8443"
+	m.Repo, m.Digest = "", ""
+	dir := writeTestEvidence(t, m, map[string]string{
+		evidenceAttestationFile: `{"format":"x","body":"y"}`,
+		evidenceCertsFile:       testCertificateChain(t, "inference.tinfoil.sh"),
+	})
+
+	ev, err := loadEvidence(discardLogger(), dir, verifyOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "inference.tinfoil.sh:8443", ev.Host)
+}
+
+func TestSaveEvidenceKeepsRekorConsistency(t *testing.T) {
+	certs, err := parseCertificateChain([]byte(testCertificateChain(t, "inference.tinfoil.sh")))
+	require.NoError(t, err)
+	ev := &evidence{
+		Host:        "inference.tinfoil.sh",
+		Repo:        "tinfoilsh/confidential-model-router",
+		Digest:      testManifest().Digest,
+		Bundle:      []byte(`{"bundle":true}`),
+		TrustRoot:   []byte(`{"trust_root":true}`),
+		Attestation: &attestation.Document{Format: "x", Body: "y"},
+		PeerCerts:   certs,
+		RekorConsistency: &consistencyProof{
+			FirstSize:  7,
+			SecondSize: 12,
+			Hashes:     [][]byte{make([]byte, 32), append(make([]byte, 31), 1)},
+		},
+	}
+	path := filepath.Join(t.TempDir(), "evidence")
+	_, err = saveEvidence(ev, path, time.Now())
+	require.NoError(t, err)
+
+	loaded, err := loadEvidence(discardLogger(), path, verifyOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, ev.RekorConsistency, loaded.RekorConsistency)
+}
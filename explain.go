@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/tinfoilsh/tinfoil-go/verifier/attestation"
+)
+
+func init() {
+	attestationCmd.AddCommand(attestationExplainCmd)
+}
+
+var attestationExplainCmd = &cobra.Command{
+	Use:   "explain <record.json|evidence>",
+	Short: "Explain the measurements in a verification record",
+	Long: `Print a register-by-register breakdown of a verification record written by
+attestation verify -l (or -j), or of saved evidence, and explain what a match
+or mismatch means.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := log.New()
+		logger.SetOutput(io.Discard)
+		record, err := loadComparableRecord(logger, args[0])
+		if err != nil {
+			return err
+		}
+		explainRecord(os.Stdout, record)
+		return nil
+	},
+}
+
+// registerLabel names a measurement register and what it covers.
+type registerLabel struct {
+	Name        string
+	Description string
+}
+
+var (
+	snpLabels = []registerLabel{
+		{"MEASUREMENT", "launch digest of the guest firmware, kernel, initrd and command line"},
+	}
+	tdxLabels = []registerLabel{
+		{"MRTD", "initial TD memory, i.e. the TDVF firmware"},
+		{"RTMR0", "firmware configuration"},
+		{"RTMR1", "kernel and boot loader"},
+		{"RTMR2", "kernel command line and initrd"},
+		{"RTMR3", "runtime extensions"},
+	}
+	multiPlatformLabels = []registerLabel{
+		snpLabels[0],
+		tdxLabels[2],
+		tdxLabels[3],
+	}
+	nitroDescriptions = []string{
+		"enclave image file",
+		"Linux kernel and bootstrap",
+		"application",
+		"parent instance IAM role",
+		"parent instance ID",
+	}
+)
+
+// registerLabels returns a label for each of the n registers of a
+// measurement of type t. Registers with no known meaning are numbered.
+func registerLabels(t attestation.PredicateType, n int) []registerLabel {
+	var known []registerLabel
+	switch {
+	case strings.Contains(string(t), "multiplatform"):
+		known = multiPlatformLabels
+	case platformName(t) == "sev-snp":
+		known = snpLabels
+	case platformName(t) == "tdx":
+		known = tdxLabels
+		if n == 2 {
+			known = tdxLabels[2:4]
+		}
+	case platformName(t) == "nitro":
+		for i := 0; i < n; i++ {
+			l := registerLabel{Name: fmt.Sprintf("PCR%d", i)}
+			if i < len(nitroDescriptions) {
+				l.Description = nitroDescriptions[i]
+			}
+			known = append(known, l)
+		}
+	}
+	labels := make([]registerLabel, n)
+	for i := range labels {
+		if i < len(known) && n <= len(known) {
+			labels[i] = known[i]
+		} else {
+			labels[i] = registerLabel{Name: fmt.Sprintf("register %d", i)}
+		}
+	}
+	return labels
+}
+
+// registerRow compares one register between the release and the enclave.
+// Either value is empty when only one side measures that register.
+type registerRow struct {
+	Label   registerLabel
+	Release string
+	Enclave string
+}
+
+func (r registerRow) compared() bool { return r.Release != "" && r.Enclave != "" }
+
+func (r registerRow) matches() bool { return r.Release == r.Enclave }
+
+// compareRegisters lines up the registers of the release and enclave
+// measurements by name, so a multi-platform release measurement can be
+// compared with the registers of the platform the enclave runs on.
+func compareRegisters(release, enclave *attestation.Measurement) []registerRow {
+	var rows []registerRow
+	index := map[string]int{}
+	add := func(m *attestation.Measurement, set func(*registerRow, string)) {
+		if m == nil {
+			return
+		}
+		for i, label := range registerLabels(m.Type, len(m.Registers)) {
+			j, ok := index[label.Name]
+			if !ok {
+				j = len(rows)
+				index[label.Name] = j
+				rows = append(rows, registerRow{Label: label})
+			}
+			set(&rows[j], m.Registers[i])
+		}
+	}
+	add(release, func(r *registerRow, v string) { r.Release = v })
+	add(enclave, func(r *registerRow, v string) { r.Enclave = v })
+	return rows
+}
+
+// differingRegisters names the registers that were compared and differ.
+func differingRegisters(release, enclave *attestation.Measurement) []string {
+	var names []string
+	for _, row := range compareRegisters(release, enclave) {
+		if row.compared() && !row.matches() {
+			names = append(names, row.Label.Name)
+		}
+	}
+	return names
+}
+
+// platformTitle is a readable name for a measurement type.
+func platformTitle(t attestation.PredicateType) string {
+	switch {
+	case strings.Contains(string(t), "multiplatform"):
+		return "Multi-platform (SEV-SNP and TDX)"
+	case platformName(t) == "sev-snp":
+		return "AMD SEV-SNP"
+	case platformName(t) == "tdx":
+		return "Intel TDX"
+	case platformName(t) == "nitro":
+		return "AWS Nitro Enclaves"
+	}
+	return "Unknown platform"
+}
+
+// explainRecord writes a register-by-register breakdown of record's
+// measurements and what the outcome means.
+func explainRecord(w io.Writer, record *auditRecord) {
+	var release *attestation.Measurement
+	if record.Measurements.Sigstore.Type != "" {
+		release = &record.Measurements.Sigstore
+	}
+	enclave, enclaveSource := record.Measurements.Enclave, "enclave"
+	if enclave == nil && record.Measurements.Cert != nil {
+		enclave, enclaveSource = record.Measurements.Cert, "certificate"
+	}
+
+	fmt.Fprintf(w, "Enclave:   %s\n", record.Enclave)
+	if record.Repo != "" {
+		fmt.Fprintf(w, "Release:   %s %s (sha256:%s)\n", record.Repo, orDash(record.Tag), orDash(record.Digest))
+	}
+	if enclave != nil {
+		fmt.Fprintf(w, "Platform:  %s (%s)\n", platformTitle(enclave.Type), enclave.Type)
+	}
+	if release != nil && (enclave == nil || release.Type != enclave.Type) {
+		fmt.Fprintf(w, "Release measurement: %s (%s)\n", platformTitle(release.Type), release.Type)
+	}
+	fmt.Fprintln(w)
+
+	rows := compareRegisters(release, enclave)
+	var differ []string
+	compared := 0
+	for _, row := range rows {
+		fmt.Fprintf(w, "%s", row.Label.Name)
+		if row.Label.Description != "" {
+			fmt.Fprintf(w, "  (%s)", row.Label.Description)
+		}
+		fmt.Fprintln(w)
+		if row.Release != "" {
+			fmt.Fprintf(w, "  %-11s  %s\n", "release", row.Release)
+		}
+		if row.Enclave != "" {
+			fmt.Fprintf(w, "  %-11s  %s\n", enclaveSource, row.Enclave)
+		}
+		switch {
+		case !row.compared():
+			fmt.Fprintf(w, "  %-11s  not compared\n", "")
+			continue
+		case row.matches():
+			fmt.Fprintf(w, "  %-11s  match\n", "")
+		default:
+			fmt.Fprintf(w, "  %-11s  DIFFERS\n", "")
+			differ = append(differ, row.Label.Name)
+		}
+		compared++
+	}
+	if len(rows) > 0 {
+		fmt.Fprintln(w)
+	}
+
+	switch {
+	case enclave == nil:
+		fmt.Fprintln(w, "The record has no enclave measurement, so the verification stopped before the enclave was checked.")
+		if record.Error != "" {
+			fmt.Fprintf(w, "Error: %s\n", record.Error)
+		}
+	case release == nil:
+		fmt.Fprintln(w, "No release was compared (no repo was given), so only the hardware attestation was checked.")
+		fmt.Fprintln(w, "The registers above show what the enclave booted, but not whether it is the published code.")
+	case len(differ) > 0:
+		verb := "differs"
+		if len(differ) > 1 {
+			verb = "differ"
+		}
+		fmt.Fprintf(w, "MISMATCH: %s %s.\n", strings.Join(differ, ", "), verb)
+		fmt.Fprintln(w, "The enclave booted different firmware, kernel or application code than the release's signed")
+		fmt.Fprintln(w, "measurement. Either it runs other code, or it was compared with the wrong release (check the")
+		fmt.Fprintln(w, "repo, --tag and --digest). Do not send it sensitive data until this is explained.")
+	case record.Reason == reasonMeasurementMismatch:
+		fmt.Fprintf(w, "MISMATCH: %s\n", record.Error)
+		fmt.Fprintln(w, "The verification rejected the enclave's measurement, although the registers above do not show")
+		fmt.Fprintln(w, "the difference. Do not send it sensitive data until this is explained.")
+	case compared == 0:
+		fmt.Fprintln(w, "NOT COMPARED: the release and the enclave measure no register in common, so nothing shows")
+		fmt.Fprintln(w, "that the enclave runs the code built for this release.")
+	default:
+		fmt.Fprintln(w, "MATCH: every compared register equals the measurement signed in the release's Sigstore bundle,")
+		fmt.Fprintln(w, "so the hardware attests that the enclave runs the code built for this release.")
+	}
+	if enclave != nil && record.Reason != "" && record.Reason != reasonMeasurementMismatch {
+		fmt.Fprintf(w, "\nVerification still failed (%s): %s\n", record.Reason, record.Error)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tinfoilsh/tinfoil-go/verifier/attestation"
+)
+
+const (
+	testSNPType   = attestation.PredicateType("https://tinfoil.sh/predicate/sev-snp-guest/v2")
+	testTDXType   = attestation.PredicateType("https://tinfoil.sh/predicate/tdx-guest/v2")
+	testMultiType = attestation.PredicateType("https://tinfoil.sh/predicate/snp-tdx-multiplatform/v1")
+)
+
+func TestRegisterLabels(t *testing.T) {
+	assert.Equal(t, "MEASUREMENT", registerLabels(testSNPType, 1)[0].Name)
+	tdx := registerLabels(testTDXType, 5)
+	assert.Equal(t, "MRTD", tdx[0].Name)
+	assert.Equal(t, "RTMR3", tdx[4].Name)
+	multi := registerLabels(testMultiType, 3)
+	assert.Equal(t, []string{"MEASUREMENT", "RTMR1", "RTMR2"}, []string{multi[0].Name, multi[1].Name, multi[2].Name})
+	assert.Equal(t, "PCR2", registerLabels("https://tinfoil.sh/predicate/aws-nitro-enclave/v1", 3)[2].Name)
+	assert.Equal(t, "register 1", registerLabels(testSNPType, 2)[1].Name, "unexpected layouts are numbered")
+}
+
+func TestCompareRegistersAcrossPlatforms(t *testing.T) {
+	release := &attestation.Measurement{Type: testMultiType, Registers: []string{"snp", "rtmr1", "rtmr2"}}
+	enclave := &attestation.Measurement{Type: testTDXType, Registers: []string{"mrtd", "rtmr0", "rtmr1", "other", "rtmr3"}}
+
+	rows := compareRegisters(release, enclave)
+	assert.Len(t, rows, 6)
+	assert.Equal(t, []string{"RTMR2"}, differingRegisters(release, enclave))
+
+	assert.Equal(t, "MEASUREMENT", rows[0].Label.Name)
+	assert.False(t, rows[0].compared(), "the SNP register is not compared with a TDX enclave")
+}
+
+func TestExplainRecord(t *testing.T) {
+	rec := newAuditRecord("enclave.example.com")
+	rec.Repo = "acme/app"
+	rec.Tag = "v1"
+	rec.Measurements.Sigstore = attestation.Measurement{Type: testSNPType, Registers: []string{"aaaa"}}
+	rec.Measurements.Enclave = &attestation.Measurement{Type: testSNPType, Registers: []string{"aaaa"}}
+
+	var out bytes.Buffer
+	explainRecord(&out, rec)
+	assert.Contains(t, out.String(), "Platform:  AMD SEV-SNP")
+	assert.Contains(t, out.String(), "MEASUREMENT  (launch digest")
+	assert.Contains(t, out.String(), "MATCH:")
+
+	rec.Measurements.Enclave = &attestation.Measurement{Type: testSNPType, Registers: []string{"bbbb"}}
+	out.Reset()
+	explainRecord(&out, rec)
+	assert.Contains(t, out.String(), "DIFFERS")
+	assert.Contains(t, out.String(), "MISMATCH: MEASUREMENT differs.")
+
+	rec.Repo = ""
+	rec.Measurements.Sigstore = attestation.Measurement{}
+	out.Reset()
+	explainRecord(&out, rec)
+	assert.Contains(t, out.String(), "No release was compared")
+}
+
+func TestExplainRecordWithoutComparedRegisters(t *testing.T) {
+	rec := newAuditRecord("enclave.example.com")
+	rec.Repo = "acme/app"
+	rec.Measurements.Sigstore = attestation.Measurement{Type: testTDXType, Registers: []string{"rtmr1", "rtmr2"}}
+	rec.Measurements.Enclave = &attestation.Measurement{Type: testSNPType, Registers: []string{"aaaa"}}
+
+	var out bytes.Buffer
+	explainRecord(&out, rec)
+	assert.Contains(t, out.String(), "NOT COMPARED:")
+	assert.NotContains(t, out.String(), "MATCH:")
+}
+
+func TestExplainRecordReportsMismatchReason(t *testing.T) {
+	rec := newAuditRecord("enclave.example.com")
+	rec.Repo = "acme/app"
+	rec.Measurements.Sigstore = attestation.Measurement{Type: testSNPType, Registers: []string{"aaaa"}}
+	rec.Measurements.Enclave = &attestation.Measurement{Type: testSNPType, Registers: []string{"aaaa"}}
+	rec.fail(reasonMeasurementMismatch, "PCR register mismatch")
+
+	var out bytes.Buffer
+	explainRecord(&out, rec)
+	assert.Contains(t, out.String(), "MISMATCH: PCR register mismatch")
+	assert.NotContains(t, out.String(), "MATCH: every")
+}
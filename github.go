@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultGitHubAPIURL = "https://api.github.com"
+	defaultGitHubURL    = "https://github.com"
+)
+
+// githubSettings select the GitHub instance that releases and attestation
+// bundles are fetched from, and the token to authenticate with.
+type githubSettings struct {
+	APIURL string
+	URL    string
+	Token  string
+}
+
+var (
+	githubOnce   sync.Once
+	githubConfig githubSettings
+)
+
+// currentGitHub returns the GitHub settings, resolved on first use from
+// the environment and the config file.
+func currentGitHub() githubSettings {
+	githubOnce.Do(func() {
+		cfg, _, err := loadConfig()
+		if err != nil {
+			cfg = cliConfig{}
+		}
+		githubConfig = resolveGitHubSettings(cfg, os.Getenv)
+	})
+	return githubConfig
+}
+
+// resolveGitHubSettings applies, in order of precedence, the environment
+// (GITHUB_API_URL, GITHUB_SERVER_URL and GITHUB_TOKEN/GH_TOKEN, as set in
+// GitHub Actions), the config file, and the public github.com defaults.
+func resolveGitHubSettings(cfg cliConfig, getenv func(string) string) githubSettings {
+	s := githubSettings{
+		APIURL: firstNonEmpty(getenv("GITHUB_API_URL"), cfg.GitHubAPIURL),
+		URL:    firstNonEmpty(getenv("GITHUB_SERVER_URL"), cfg.GitHubURL),
+		Token:  firstNonEmpty(getenv("GITHUB_TOKEN"), getenv("GH_TOKEN")),
+	}
+
+	// GitHub Enterprise Server serves its API under /api/v3 of the web host,
+	// so either URL is enough to derive the other.
+	switch {
+	case s.APIURL == "" && s.URL == "":
+		s.APIURL, s.URL = defaultGitHubAPIURL, defaultGitHubURL
+	case s.APIURL == "":
+		s.URL = strings.TrimRight(s.URL, "/")
+		s.APIURL = defaultGitHubAPIURL
+		if s.URL != defaultGitHubURL {
+			s.APIURL = s.URL + "/api/v3"
+		}
+	case s.URL == "":
+		s.APIURL = strings.TrimRight(s.APIURL, "/")
+		s.URL = defaultGitHubURL
+		if s.APIURL != defaultGitHubAPIURL {
+			s.URL = strings.TrimSuffix(s.APIURL, "/api/v3")
+		}
+	}
+	s.APIURL = strings.TrimRight(s.APIURL, "/")
+	s.URL = strings.TrimRight(s.URL, "/")
+
+	if s.Token == "" && cfg.GitHubTokenCommand != "" {
+		token, err := runTokenCommand(cfg.GitHubTokenCommand)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: github_token_command failed (%v); using anonymous GitHub access\n", err)
+		}
+		s.Token = token
+	}
+	return s
+}
+
+// runTokenCommand runs a credential helper such as `gh auth token` and
+// returns the token it prints.
+func runTokenCommand(command string) (string, error) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// fetchAttestationBundle returns the Sigstore bundle GitHub stores for the
+// attestation of digest in repo. Bundles are immutable and cached for a week.
+func fetchAttestationBundle(repo, digest string) ([]byte, error) {
+	url := fmt.Sprintf("%s/repos/%s/attestations/sha256:%s", currentGitHub().APIURL, repo, digest)
+	return cachedFetch(cacheKindBundle, url, cacheTTLBundle, cacheMaxStaleBundle, func() ([]byte, error) {
+		return lookupAttestationBundle(url, repo, digest)
+	})
+}
+
+func lookupAttestationBundle(url, repo, digest string) ([]byte, error) {
+	var resp struct {
+		Attestations []struct {
+			Bundle json.RawMessage `json:"bundle"`
+		} `json:"attestations"`
+	}
+	if err := githubGetJSON(url, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Attestations) == 0 || len(resp.Attestations[0].Bundle) == 0 {
+		return nil, fmt.Errorf("no attestation found for %s@sha256:%s", repo, digest)
+	}
+	return resp.Attestations[0].Bundle, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useGitHub overrides the resolved GitHub settings for the duration of t.
+func useGitHub(t *testing.T, s githubSettings) {
+	t.Helper()
+	githubOnce.Do(func() {})
+	prev := githubConfig
+	githubConfig = s
+	t.Cleanup(func() { githubConfig = prev })
+}
+
+func TestResolveGitHubSettings(t *testing.T) {
+	env := func(vars map[string]string) func(string) string {
+		return func(k string) string { return vars[k] }
+	}
+
+	s := resolveGitHubSettings(cliConfig{}, env(nil))
+	assert.Equal(t, githubSettings{APIURL: defaultGitHubAPIURL, URL: defaultGitHubURL}, s)
+
+	s = resolveGitHubSettings(cliConfig{GitHubURL: "https://ghe.example.com/"}, env(nil))
+	assert.Equal(t, "https://ghe.example.com/api/v3", s.APIURL)
+	assert.Equal(t, "https://ghe.example.com", s.URL)
+
+	s = resolveGitHubSettings(cliConfig{GitHubAPIURL: "https://ghe.example.com/api/v3"}, env(nil))
+	assert.Equal(t, "https://ghe.example.com", s.URL)
+
+	s = resolveGitHubSettings(cliConfig{GitHubAPIURL: "https://ghe.example.com/api/v3"}, env(map[string]string{
+		"GITHUB_API_URL":    "https://api.github.com",
+		"GITHUB_SERVER_URL": "https://github.com",
+		"GH_TOKEN":          "gh-token",
+	}))
+	assert.Equal(t, githubSettings{APIURL: defaultGitHubAPIURL, URL: defaultGitHubURL, Token: "gh-token"}, s)
+
+	s = resolveGitHubSettings(cliConfig{GitHubTokenCommand: "echo helper-token"}, env(map[string]string{"GITHUB_TOKEN": "env-token"}))
+	assert.Equal(t, "env-token", s.Token)
+	s = resolveGitHubSettings(cliConfig{GitHubTokenCommand: "echo helper-token"}, env(nil))
+	assert.Equal(t, "helper-token", s.Token)
+}
+
+func TestFetchAttestationBundle(t *testing.T) {
+	t.Setenv(envCacheDir, t.TempDir())
+	var gotAuth, gotPath string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		gotPath = r.URL.Path
+		if r.URL.Path == "/api/v3/repos/acme/empty/attestations/sha256:abc" {
+			w.Write([]byte(`{"attestations":[]}`))
+			return
+		}
+		w.Write([]byte(`{"attestations":[{"bundle":{"mediaType":"bundle"}},{"bundle":{}}]}`))
+	}))
+	defer srv.Close()
+	useGitHub(t, githubSettings{APIURL: srv.URL + "/api/v3", URL: srv.URL, Token: "secret"})
+
+	bundle, err := fetchAttestationBundle("acme/app", "abc")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"mediaType":"bundle"}`, string(bundle))
+	assert.Equal(t, "/api/v3/repos/acme/app/attestations/sha256:abc", gotPath)
+	assert.Equal(t, "Bearer secret", gotAuth)
+
+	_, err = fetchAttestationBundle("acme/empty", "abc")
+	assert.ErrorContains(t, err, "no attestation found")
+}
+
+func TestGitHubGetOnlySendsTokenToGitHub(t *testing.T) {
+	var gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+	}))
+	defer srv.Close()
+	useGitHub(t, githubSettings{APIURL: defaultGitHubAPIURL, URL: defaultGitHubURL, Token: "secret"})
+
+	_, err := githubGet(srv.URL + "/asset")
+	require.NoError(t, err)
+	assert.Empty(t, gotAuth)
+}
+
+func TestGitHubGetRateLimitHint(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+	useGitHub(t, githubSettings{APIURL: srv.URL, URL: srv.URL})
+
+	_, err := githubGet(srv.URL + "/repos/acme/app/releases/latest")
+	assert.ErrorContains(t, err, "set GITHUB_TOKEN")
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// allowNoHPKE backs the --allow-no-hpke flag.
+var allowNoHPKE bool
+
+const allowNoHPKEUsage = "Accept an enclave that attests an HPKE key but does not serve one"
+
+// hpkeKeysPath is where an enclave serves the HPKE key configuration used
+// by the encrypted HTTP body protocol, in the OHTTP key configuration
+// format (RFC 9458, section 3).
+const hpkeKeysPath = "/.well-known/hpke-keys"
+
+// hpkePublicKeySizes maps HPKE KEM identifiers (RFC 9180, section 7.1) to
+// the size of their encoded public keys.
+var hpkePublicKeySizes = map[uint16]int{
+	0x0010: 65,  // DHKEM(P-256)
+	0x0011: 97,  // DHKEM(P-384)
+	0x0012: 133, // DHKEM(P-521)
+	0x0020: 32,  // DHKEM(X25519)
+	0x0021: 56,  // DHKEM(X448)
+}
+
+// fetchHPKEKeys downloads the enclave's HPKE key configuration over a TLS
+// connection pinned to keyFP, the fingerprint of the certificate key that is
+// checked against the attestation. It returns nil without an error if the
+// enclave does not serve one.
+func fetchHPKEKeys(host, keyFP string) ([]byte, error) {
+	resp, err := keyPinnedClient(keyFP).Get("https://" + host + hpkeKeysPath)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", hpkeKeysPath, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+}
+
+// parseHPKEKeys returns the hex-encoded public keys in an OHTTP key
+// configuration list. Both the length-prefixed list form
+// (application/ohttp-keys) and a single bare configuration are accepted.
+func parseHPKEKeys(data []byte) ([]string, error) {
+	if len(data) >= 2 && int(binary.BigEndian.Uint16(data))+2 <= len(data) {
+		if keys, err := parseHPKEKeyList(data); err == nil {
+			return keys, nil
+		}
+	}
+	key, rest, err := parseHPKEKeyConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("trailing data after HPKE key configuration")
+	}
+	return []string{key}, nil
+}
+
+func parseHPKEKeyList(data []byte) ([]string, error) {
+	var keys []string
+	for len(data) > 0 {
+		if len(data) < 2 {
+			return nil, fmt.Errorf("truncated HPKE key configuration list")
+		}
+		n := int(binary.BigEndian.Uint16(data))
+		data = data[2:]
+		if n > len(data) {
+			return nil, fmt.Errorf("truncated HPKE key configuration list")
+		}
+		key, rest, err := parseHPKEKeyConfig(data[:n])
+		if err != nil {
+			return nil, err
+		}
+		if len(rest) != 0 {
+			return nil, fmt.Errorf("trailing data in HPKE key configuration")
+		}
+		keys = append(keys, key)
+		data = data[n:]
+	}
+	return keys, nil
+}
+
+// parseHPKEKeyConfig reads one key configuration: key ID, KEM ID, public
+// key, and the list of KDF/AEAD pairs.
+func parseHPKEKeyConfig(data []byte) (string, []byte, error) {
+	if len(data) < 3 {
+		return "", nil, fmt.Errorf("truncated HPKE key configuration")
+	}
+	kem := binary.BigEndian.Uint16(data[1:3])
+	size, ok := hpkePublicKeySizes[kem]
+	if !ok {
+		return "", nil, fmt.Errorf("unsupported HPKE KEM %#04x", kem)
+	}
+	data = data[3:]
+	if len(data) < size+2 {
+		return "", nil, fmt.Errorf("truncated HPKE key configuration")
+	}
+	key := hex.EncodeToString(data[:size])
+	data = data[size:]
+	n := int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	if n%4 != 0 || n > len(data) {
+		return "", nil, fmt.Errorf("invalid HPKE symmetric algorithms length %d", n)
+	}
+	return key, data[n:], nil
+}
+
+// checkHPKEKey compares the attested HPKE public key with the keys the
+// enclave serves and returns the first served key. Every served key must be
+// the attested one: OHTTP clients use the first configuration, and any
+// other key could be picked by a client that orders them differently.
+func checkHPKEKey(attested string, served []string) (string, error) {
+	if len(served) == 0 {
+		return "", fmt.Errorf("enclave serves no HPKE keys")
+	}
+	for i, k := range served {
+		if !strings.EqualFold(k, attested) {
+			return served[0], fmt.Errorf("served HPKE key #%d %s does not match attested key %s", i+1, k, attested)
+		}
+	}
+	return served[0], nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testHPKEKeyConfig builds an X25519 key configuration with one
+// HKDF-SHA256/AES-128-GCM pair.
+func testHPKEKeyConfig(key []byte) []byte {
+	cfg := []byte{0x01, 0x00, 0x20}
+	cfg = append(cfg, key...)
+	return append(cfg, 0x00, 0x04, 0x00, 0x01, 0x00, 0x01)
+}
+
+func TestParseHPKEKeys(t *testing.T) {
+	key := bytes.Repeat([]byte{0xab}, 32)
+	cfg := testHPKEKeyConfig(key)
+
+	keys, err := parseHPKEKeys(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{hex.EncodeToString(key)}, keys)
+
+	other := bytes.Repeat([]byte{0xcd}, 32)
+	otherCfg := testHPKEKeyConfig(other)
+	list := append([]byte{0x00, byte(len(cfg))}, cfg...)
+	list = append(list, 0x00, byte(len(otherCfg)))
+	list = append(list, otherCfg...)
+	keys, err = parseHPKEKeys(list)
+	require.NoError(t, err)
+	assert.Equal(t, []string{hex.EncodeToString(key), hex.EncodeToString(other)}, keys)
+
+	_, err = parseHPKEKeys(cfg[:20])
+	assert.Error(t, err)
+	_, err = parseHPKEKeys([]byte{0x01, 0x99, 0x99, 0x00})
+	assert.Error(t, err)
+}
+
+func TestCheckHPKEKey(t *testing.T) {
+	served, err := checkHPKEKey("ABCD", []string{"abcd", "AbCd"})
+	assert.NoError(t, err)
+	assert.Equal(t, "abcd", served)
+
+	// A rogue key first, or anywhere in the list, fails the check.
+	served, err = checkHPKEKey("abcd", []string{"1234", "abcd"})
+	assert.ErrorContains(t, err, "#1 1234")
+	assert.Equal(t, "1234", served)
+	_, err = checkHPKEKey("abcd", []string{"abcd", "1234"})
+	assert.ErrorContains(t, err, "#2 1234")
+
+	served, err = checkHPKEKey("abcd", []string{"1234"})
+	assert.Error(t, err)
+	assert.Equal(t, "1234", served)
+
+	_, err = checkHPKEKey("abcd", nil)
+	assert.Error(t, err)
+}
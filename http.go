@@ -1,9 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"net/http"
 	"strings"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
 	"github.com/tinfoilsh/tinfoil-go/verifier/client"
@@ -11,13 +15,82 @@ import (
 
 var requestHeaders []string
 
-func secureClient() *client.SecureClient {
-	return client.NewSecureClient(enclaveHost, repo)
+// verifiedHTTPClient returns an HTTP client bound to the verified enclave.
+// The tinfoil-go client always compares against the latest release and
+// makes neither the enclave mode nor the known_enclaves check, so unless
+// useLibraryClient allows it, our own verification and key pinning is used.
+func verifiedHTTPClient() (*http.Client, error) {
+	if useLibraryClient() {
+		return client.NewSecureClient(enclaveHost, repo).HTTPClient()
+	}
+	opts, err := pinnedVerifyOptions()
+	if err != nil {
+		return nil, err
+	}
+	httpClient, record, err := newPinnedHTTPClient(log.StandardLogger(), opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKnownEnclave(log.StandardLogger(), record); err != nil {
+		return nil, err
+	}
+	return httpClient, nil
+}
+
+// sendRequest performs a non-streaming request through the verified enclave
+// connection and returns the response body.
+func sendRequest(method, url string, headers map[string]string, body []byte) ([]byte, error) {
+	if useLibraryClient() {
+		sc := client.NewSecureClient(enclaveHost, repo)
+		if method == http.MethodGet {
+			resp, err := sc.Get(url, headers)
+			if err != nil {
+				return nil, err
+			}
+			return resp.Body, nil
+		}
+		resp, err := sc.Post(url, headers, body)
+		if err != nil {
+			return nil, err
+		}
+		return resp.Body, nil
+	}
+
+	httpClient, err := verifiedHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if method == http.MethodPost && !hasRequestHeader(headers, "Content-Type") {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
 }
 
 func init() {
 	rootCmd.AddCommand(httpCmd)
 	httpCmd.PersistentFlags().StringArrayVarP(&requestHeaders, "header", "H", nil, `HTTP request header ("Name: Value"); may be repeated`)
+	httpCmd.PersistentFlags().StringVar(&releaseTag, "tag", "", "Verify against this release tag instead of the latest release")
+	httpCmd.PersistentFlags().StringVar(&releaseDigest, "digest", "", "Verify against this release digest instead of the latest release")
+	httpCmd.PersistentFlags().StringVar(&trustRootPath, "trust-root", "", trustRootUsage)
+	httpCmd.PersistentFlags().StringVar(&policyPath, "policy", "", "Verify against the allowlist in this policy file instead of a single release")
+	httpCmd.PersistentFlags().StringSliceVar(&platformRequirements.MinTCB, "min-tcb", nil, minTCBUsage)
+	httpCmd.PersistentFlags().BoolVar(&platformRequirements.NoDebug, "no-debug", false, noDebugUsage)
+	httpCmd.PersistentFlags().BoolVar(&modeAllowances.Debug, "allow-debug", false, allowDebugUsage)
+	httpCmd.PersistentFlags().BoolVar(&modeAllowances.NonCC, "allow-non-cc", false, allowNonCCUsage)
+	httpCmd.PersistentFlags().BoolVar(&allowNoHPKE, "allow-no-hpke", false, allowNoHPKEUsage)
+	httpCmd.PersistentFlags().StringVar(&knownEnclavesMode, "known-enclaves", knownEnclavesWarn, knownEnclavesUsage)
 }
 
 var httpCmd = &cobra.Command{
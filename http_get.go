@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"net/http"
 
 	"github.com/spf13/cobra"
 )
@@ -19,12 +20,18 @@ var httpGetCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
+		if err := validateKnownEnclavesMode(); err != nil {
+			return err
+		}
+		if _, err := parseMinTCB(platformRequirements.MinTCB); err != nil {
+			return err
+		}
 
-		resp, err := secureClient().Get(args[0], headers)
+		respBody, err := sendRequest(http.MethodGet, args[0], headers, nil)
 		if err != nil {
 			return err
 		}
-		fmt.Println(string(resp.Body))
+		fmt.Println(string(respBody))
 		return nil
 	},
 }
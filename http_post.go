@@ -26,12 +26,17 @@ var httpPostCmd = &cobra.Command{
 	Args:  cobra.MinimumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		url := args[0]
-		sc := secureClient()
 
 		headers, err := parseRequestHeaders(requestHeaders)
 		if err != nil {
 			return err
 		}
+		if err := validateKnownEnclavesMode(); err != nil {
+			return err
+		}
+		if _, err := parseMinTCB(platformRequirements.MinTCB); err != nil {
+			return err
+		}
 
 		if stream {
 			// Build a raw HTTP POST request with the provided body.
@@ -47,7 +52,7 @@ var httpPostCmd = &cobra.Command{
 			}
 
 			// Use the verifier’s HTTP client.
-			client, err := sc.HTTPClient()
+			client, err := verifiedHTTPClient()
 			if err != nil {
 				return fmt.Errorf("error getting HTTP client: %w", err)
 			}
@@ -65,11 +70,11 @@ var httpPostCmd = &cobra.Command{
 				return fmt.Errorf("error reading stream: %w", err)
 			}
 		} else { // Not streaming
-			resp, err := sc.Post(url, headers, []byte(body))
+			respBody, err := sendRequest(http.MethodPost, url, headers, []byte(body))
 			if err != nil {
 				return err
 			}
-			fmt.Println(string(resp.Body))
+			fmt.Println(string(respBody))
 		}
 
 		return nil
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/tinfoilsh/tinfoil-go/verifier/attestation"
+)
+
+// knownEnclavesMode backs the --known-enclaves flag.
+var knownEnclavesMode string
+
+// Values of --known-enclaves.
+const (
+	knownEnclavesWarn   = "warn"   // warn when a known enclave changed
+	knownEnclavesStrict = "strict" // fail when a known enclave changed
+	knownEnclavesOff    = "off"    // neither check nor record enclaves
+)
+
+// knownEnclavesMu serializes updates of the known_enclaves file by
+// concurrent verifications, e.g. with --all-routers.
+var knownEnclavesMu sync.Mutex
+
+const knownEnclavesUsage = "What to do when a known enclave changed without a new release: warn, strict (fail) or off"
+
+// knownEnclave is what was trusted for a host the first time it verified,
+// like an SSH known_hosts entry.
+type knownEnclave struct {
+	Repo        string                   `json:"repo,omitempty"`
+	Tag         string                   `json:"tag,omitempty"`
+	Digest      string                   `json:"digest,omitempty"`
+	Measurement *attestation.Measurement `json:"measurement"`
+	KeyFP       string                   `json:"key_fp"`
+	FirstSeen   string                   `json:"first_seen"`
+	Updated     string                   `json:"updated"`
+}
+
+func init() {
+	attestationCmd.AddCommand(knownEnclavesCmd)
+	knownEnclavesCmd.AddCommand(knownEnclavesListCmd, knownEnclavesAcceptCmd, knownEnclavesForgetCmd)
+	knownEnclavesAcceptCmd.Flags().StringVar(&releaseTag, "tag", "", "Verify against this release tag instead of the latest release")
+	knownEnclavesAcceptCmd.Flags().StringVar(&releaseDigest, "digest", "", "Verify against this release digest instead of the latest release")
+	knownEnclavesAcceptCmd.Flags().BoolVar(&modeAllowances.Debug, "allow-debug", false, allowDebugUsage)
+	knownEnclavesAcceptCmd.Flags().BoolVar(&modeAllowances.NonCC, "allow-non-cc", false, allowNonCCUsage)
+	knownEnclavesAcceptCmd.Flags().BoolVar(&allowNoHPKE, "allow-no-hpke", false, allowNoHPKEUsage)
+}
+
+var knownEnclavesCmd = &cobra.Command{
+	Use:   "known",
+	Short: "Manage the enclaves trusted on first use",
+	Long: `The first time an enclave verifies, its measurement, TLS key fingerprint and
+repo are recorded in ~/.tinfoil/known_enclaves. Later verifications from
+attestation verify, http and proxy warn (or, with --known-enclaves strict,
+fail) when they change without a new release.`,
+}
+
+var knownEnclavesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List known enclaves",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		known, err := loadKnownEnclaves()
+		if err != nil {
+			return err
+		}
+		if len(known) == 0 {
+			fmt.Println("No known enclaves.")
+			return nil
+		}
+		fmt.Printf("%-36s  %-36s  %-10s  %-16s  %s\n", "HOST", "REPO", "TAG", "KEY", "UPDATED")
+		for _, host := range sortedHosts(known) {
+			e := known[host]
+			fmt.Printf("%-36s  %-36s  %-10s  %-16s  %s\n",
+				truncate(host, 36), truncate(orDash(e.Repo), 36), truncate(orDash(e.Tag), 10), truncate(e.KeyFP, 16), e.Updated)
+		}
+		return nil
+	},
+}
+
+var knownEnclavesAcceptCmd = &cobra.Command{
+	Use:   "accept <host>",
+	Short: "Verify an enclave and trust its current state",
+	Long: `Verify host and replace its known_enclaves entry with the result, e.g. after
+an expected restart. The repo defaults to -r, then to the repo already
+recorded for the host.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		known, err := loadKnownEnclaves()
+		if err != nil {
+			return err
+		}
+		opts := currentVerifyOptions()
+		opts.Host = args[0]
+		if opts.Repo == "" {
+			if e, ok := known[opts.Host]; ok {
+				opts.Repo = e.Repo
+			}
+		}
+
+		logger := log.New()
+		logger.SetOutput(os.Stderr)
+		if verbose {
+			logger.SetLevel(log.DebugLevel)
+		}
+		record, err := verifyAttestation(logger, opts)
+		if err != nil {
+			return err
+		}
+		known[record.Enclave] = knownEnclaveFor(record, known[record.Enclave])
+		if err := saveKnownEnclaves(known); err != nil {
+			return err
+		}
+		fmt.Printf("Accepted %s (key %s)\n", record.Enclave, record.Keys.Enclave)
+		return nil
+	},
+}
+
+var knownEnclavesForgetCmd = &cobra.Command{
+	Use:   "forget <host>",
+	Short: "Remove an enclave from known_enclaves",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		known, err := loadKnownEnclaves()
+		if err != nil {
+			return err
+		}
+		if _, ok := known[args[0]]; !ok {
+			return fmt.Errorf("%s is not a known enclave", args[0])
+		}
+		delete(known, args[0])
+		if err := saveKnownEnclaves(known); err != nil {
+			return err
+		}
+		fmt.Printf("Forgot %s\n", args[0])
+		return nil
+	},
+}
+
+func knownEnclavesPath() (string, error) {
+	if p := os.Getenv(envKnownEnclaves); p != "" {
+		return p, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("locating home directory: %w", err)
+	}
+	return filepath.Join(home, ".tinfoil", "known_enclaves"), nil
+}
+
+// loadKnownEnclaves reads the known_enclaves file, keyed by host. A missing
+// file is an empty set.
+func loadKnownEnclaves() (map[string]*knownEnclave, error) {
+	path, err := knownEnclavesPath()
+	if err != nil {
+		return nil, err
+	}
+	known := map[string]*knownEnclave{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return known, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading known enclaves: %w", err)
+	}
+	if err := json.Unmarshal(data, &known); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return known, nil
+}
+
+func saveKnownEnclaves(known map[string]*knownEnclave) error {
+	path, err := knownEnclavesPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(known, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, append(data, '\n')); err != nil {
+		return fmt.Errorf("writing known enclaves: %w", err)
+	}
+	return nil
+}
+
+// knownEnclaveFor builds the entry for a verified record. prev is the
+// entry it replaces, or nil.
+func knownEnclaveFor(record *auditRecord, prev *knownEnclave) *knownEnclave {
+	now := time.Now().UTC().Format(time.RFC3339)
+	e := &knownEnclave{
+		Repo:        record.Repo,
+		Tag:         record.Tag,
+		Digest:      record.Digest,
+		Measurement: record.Measurements.Enclave,
+		KeyFP:       record.Keys.Enclave,
+		FirstSeen:   now,
+		Updated:     now,
+	}
+	if prev != nil {
+		e.FirstSeen = prev.FirstSeen
+		if e.Repo == "" {
+			e.Repo, e.Tag, e.Digest = prev.Repo, prev.Tag, prev.Digest
+		}
+	}
+	return e
+}
+
+// knownEnclaveChanges describes how a verified record differs from the
+// known entry for its host.
+func knownEnclaveChanges(known *knownEnclave, record *auditRecord) []string {
+	var changes []string
+	if record.Repo != "" && known.Repo != "" && record.Repo != known.Repo {
+		changes = append(changes, fmt.Sprintf("repo %s -> %s", known.Repo, record.Repo))
+	}
+	if !sameMeasurement(known.Measurement, record.Measurements.Enclave) {
+		changes = append(changes, "measurement changed")
+	}
+	if known.KeyFP != record.Keys.Enclave {
+		changes = append(changes, fmt.Sprintf("TLS key %s -> %s", known.KeyFP, record.Keys.Enclave))
+	}
+	return changes
+}
+
+// isNewRelease reports whether record was verified against a different
+// release of the same repo than the known entry, which explains a changed
+// measurement and key.
+func isNewRelease(known *knownEnclave, record *auditRecord) bool {
+	return record.Digest != "" && record.Digest != known.Digest &&
+		(known.Repo == "" || record.Repo == known.Repo)
+}
+
+// checkKnownEnclave compares a successful verification with the host's
+// known_enclaves entry. Unknown hosts are recorded, and changes that come
+// with a new release update the entry. Other changes are reported as a
+// warning, or as a known_enclave_changed error with --known-enclaves strict.
+func checkKnownEnclave(l *log.Logger, record *auditRecord) error {
+	if knownEnclavesMode == knownEnclavesOff || record.Keys.Enclave == "" ||
+		(record.Status != statusOK && record.Status != statusEnclaveOnly) {
+		return nil
+	}
+	knownEnclavesMu.Lock()
+	defer knownEnclavesMu.Unlock()
+	known, err := loadKnownEnclaves()
+	if err != nil {
+		return err
+	}
+
+	entry, ok := known[record.Enclave]
+	switch {
+	case !ok:
+		l.Infof("Adding %s to known enclaves", record.Enclave)
+	case len(knownEnclaveChanges(entry, record)) == 0:
+		return nil
+	case isNewRelease(entry, record):
+		l.Infof("Known enclave %s moved to release %s", record.Enclave, orDash(record.Tag))
+	default:
+		msg := fmt.Sprintf("known enclave %s changed without a new release (%s); run `tinfoil attestation known accept %s` if this is expected",
+			record.Enclave, strings.Join(knownEnclaveChanges(entry, record), ", "), record.Enclave)
+		if knownEnclavesMode == knownEnclavesStrict {
+			return verifyErrorf(reasonKnownEnclaveChanged, "%s", msg)
+		}
+		fmt.Fprintf(os.Stderr, "warning: %s\n", msg)
+		return nil
+	}
+
+	known[record.Enclave] = knownEnclaveFor(record, entry)
+	return saveKnownEnclaves(known)
+}
+
+func validateKnownEnclavesMode() error {
+	switch knownEnclavesMode {
+	case knownEnclavesWarn, knownEnclavesStrict, knownEnclavesOff:
+		return nil
+	}
+	return fmt.Errorf("unknown --known-enclaves %q: expected warn, strict or off", knownEnclavesMode)
+}
+
+func sortedHosts(known map[string]*knownEnclave) []string {
+	hosts := make([]string, 0, len(known))
+	for h := range known {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io"
+	"path/filepath"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tinfoilsh/tinfoil-go/verifier/attestation"
+)
+
+func knownTestRecord(digest, key string, registers ...string) *auditRecord {
+	rec := newAuditRecord("enclave.example.com")
+	rec.Status = statusOK
+	rec.Repo = "acme/app"
+	rec.Digest = digest
+	rec.Measurements.Enclave = &attestation.Measurement{Type: "t", Registers: registers}
+	rec.Keys.Enclave = key
+	return rec
+}
+
+func TestCheckKnownEnclave(t *testing.T) {
+	t.Setenv(envKnownEnclaves, filepath.Join(t.TempDir(), "known_enclaves"))
+	defer func(m string) { knownEnclavesMode = m }(knownEnclavesMode)
+	knownEnclavesMode = knownEnclavesStrict
+	l := log.New()
+	l.SetOutput(io.Discard)
+
+	// First use records the enclave.
+	require.NoError(t, checkKnownEnclave(l, knownTestRecord("d1", "k1", "r1")))
+	known, err := loadKnownEnclaves()
+	require.NoError(t, err)
+	require.Contains(t, known, "enclave.example.com")
+	assert.Equal(t, "k1", known["enclave.example.com"].KeyFP)
+	firstSeen := known["enclave.example.com"].FirstSeen
+
+	require.NoError(t, checkKnownEnclave(l, knownTestRecord("d1", "k1", "r1")))
+
+	// A changed key without a new release fails in strict mode.
+	err = checkKnownEnclave(l, knownTestRecord("d1", "k2", "r1"))
+	assert.Equal(t, reasonKnownEnclaveChanged, reasonOf(err))
+	assert.ErrorContains(t, err, "TLS key k1 -> k2")
+
+	knownEnclavesMode = knownEnclavesWarn
+	assert.NoError(t, checkKnownEnclave(l, knownTestRecord("d1", "k2", "r1")))
+	known, err = loadKnownEnclaves()
+	require.NoError(t, err)
+	assert.Equal(t, "k1", known["enclave.example.com"].KeyFP, "warnings do not update the entry")
+
+	// A new release explains the change and updates the entry.
+	knownEnclavesMode = knownEnclavesStrict
+	require.NoError(t, checkKnownEnclave(l, knownTestRecord("d2", "k3", "r2")))
+	known, err = loadKnownEnclaves()
+	require.NoError(t, err)
+	e := known["enclave.example.com"]
+	assert.Equal(t, "d2", e.Digest)
+	assert.Equal(t, "k3", e.KeyFP)
+	assert.Equal(t, firstSeen, e.FirstSeen)
+
+	// A different repo is not a new release.
+	other := knownTestRecord("d3", "k4", "r3")
+	other.Repo = "evil/app"
+	assert.Equal(t, reasonKnownEnclaveChanged, reasonOf(checkKnownEnclave(l, other)))
+
+	// Failed verifications are neither recorded nor compared.
+	failed := knownTestRecord("d9", "k9", "r9")
+	failed.Status = statusFail
+	assert.NoError(t, checkKnownEnclave(l, failed))
+}
+
+func TestKnownEnclaveForKeepsRepoForEnclaveOnly(t *testing.T) {
+	prev := &knownEnclave{
+		Repo:        "acme/app",
+		Tag:         "v1",
+		Digest:      "d1",
+		Measurement: &attestation.Measurement{Type: "t", Registers: []string{"r1"}},
+		KeyFP:       "k1",
+		FirstSeen:   "2024-01-01T00:00:00Z",
+	}
+	rec := knownTestRecord("", "k2", "r1")
+	rec.Repo = ""
+	rec.Status = statusEnclaveOnly
+
+	e := knownEnclaveFor(rec, prev)
+	assert.Equal(t, "acme/app", e.Repo)
+	assert.Equal(t, "d1", e.Digest)
+	assert.Equal(t, "k2", e.KeyFP)
+	assert.Equal(t, prev.FirstSeen, e.FirstSeen)
+	assert.Equal(t, []string{"TLS key k1 -> k2"}, knownEnclaveChanges(prev, rec))
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/tinfoilsh/tinfoil-go/verifier/attestation"
+)
+
+// newPinnedHTTPClient verifies the enclave selected by opts and returns an
+// HTTP client that only talks to that enclave over a TLS connection whose
+// key matches the attested one. It is used instead of the tinfoil-go client
+// when verification options the library does not support (such as --tag or
+// --digest) are in effect.
+func newPinnedHTTPClient(l *log.Logger, opts verifyOptions) (*http.Client, *auditRecord, error) {
+	record, err := verifyPinned(l, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts.Host = record.Enclave
+
+	t := &pinnedTransport{
+		logger: l,
+		opts:   opts,
+		keyFP:  record.Keys.Enclave,
+	}
+	t.base = pinnedBaseTransport(t.verifyConnection)
+
+	return &http.Client{Transport: t}, record, nil
+}
+
+// keyPinnedClient returns an HTTP client that only completes TLS handshakes
+// with a certificate whose key fingerprint is keyFP. Unlike pinnedTransport
+// it never re-verifies: a different key fails the request.
+func keyPinnedClient(keyFP string) *http.Client {
+	return &http.Client{
+		Timeout: enclaveHTTP.Timeout,
+		Transport: pinnedBaseTransport(func(cs tls.ConnectionState) error {
+			fp, err := attestation.ConnectionCertFP(cs)
+			if err != nil {
+				return fmt.Errorf("computing certificate fingerprint: %w", err)
+			}
+			if fp != keyFP {
+				return fmt.Errorf("certificate key %s does not match pinned key %s", fp, keyFP)
+			}
+			return nil
+		}),
+	}
+}
+
+// pinnedBaseTransport returns a transport that runs verify on every TLS
+// connection after the usual certificate checks.
+func pinnedBaseTransport(verify func(tls.ConnectionState) error) *http.Transport {
+	base := verifierTransport.Clone()
+	base.TLSClientConfig = &tls.Config{VerifyConnection: verify}
+	return base
+}
+
+// verifyPinned runs verifyAttestation and rejects anything short of a full
+// match against the release, so callers never send traffic to an unverified
+// enclave.
+func verifyPinned(l *log.Logger, opts verifyOptions) (*auditRecord, error) {
+	record, err := verifyAttestation(l, opts)
+	if err != nil {
+		return nil, err
+	}
+	if record.Status != statusOK {
+		return nil, fmt.Errorf("enclave %s was not verified against a release (status %s)", record.Enclave, record.Status)
+	}
+	return record, nil
+}
+
+// pinnedTransport implements http.RoundTripper and restricts requests to the
+// verified enclave. When the enclave presents a different TLS key (e.g. after
+// a restart), the attestation is re-verified before the new key is trusted.
+type pinnedTransport struct {
+	logger *log.Logger
+	opts   verifyOptions
+	base   *http.Transport
+
+	mu    sync.Mutex
+	keyFP string
+}
+
+func (t *pinnedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "https" || !strings.EqualFold(enclaveAddr(req.URL.Host), enclaveAddr(t.opts.Host)) {
+		return nil, fmt.Errorf("refusing request to %s: only https://%s is verified", req.URL.Host, t.opts.Host)
+	}
+	return t.base.RoundTrip(req)
+}
+
+func (t *pinnedTransport) verifyConnection(cs tls.ConnectionState) error {
+	fp, err := attestation.ConnectionCertFP(cs)
+	if err != nil {
+		return fmt.Errorf("computing certificate fingerprint: %w", err)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if fp == t.keyFP {
+		return nil
+	}
+
+	t.logger.Warn("Enclave certificate changed, re-verifying attestation")
+	record, err := verifyPinned(t.logger, t.opts)
+	if err != nil {
+		return fmt.Errorf("re-verifying enclave: %w", err)
+	}
+	t.keyFP = record.Keys.Enclave
+	if fp != t.keyFP {
+		return fmt.Errorf("certificate key %s does not match attested key %s", fp, t.keyFP)
+	}
+	return nil
+}
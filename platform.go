@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tinfoilsh/tinfoil-go/verifier/attestation"
+)
+
+// platformReport holds the platform details of an attestation that the
+// measurement alone does not show: TCB versions and guest policy bits.
+type platformReport struct {
+	Platform string `json:"platform"`
+
+	// TCB holds the security version numbers of the platform's firmware
+	// components, keyed by component name (see tcbComponents).
+	TCB map[string]int `json:"tcb"`
+
+	Debug     bool `json:"debug"`               // guest can be debugged by the host
+	Migration bool `json:"migration,omitempty"` // guest may be migrated by a migration agent
+	SMT       bool `json:"smt,omitempty"`       // simultaneous multithreading enabled
+
+	// Platform-specific fields, hex encoded.
+	Policy       string `json:"policy,omitempty"`        // SEV-SNP guest policy
+	ChipID       string `json:"chip_id,omitempty"`       // SEV-SNP
+	TDAttributes string `json:"td_attributes,omitempty"` // TDX
+	TEETCBSVN    string `json:"tee_tcb_svn,omitempty"`   // TDX
+}
+
+// tcbComponents are the names accepted by --min-tcb, per platform.
+var tcbComponents = map[string][]string{
+	"sev-snp": {"bootloader", "tee", "snp", "microcode"},
+	"tdx":     {"tdx_module", "tdx_module_major"},
+}
+
+// platformRequirements back the --min-tcb and --no-debug flags.
+var platformRequirements struct {
+	MinTCB  []string
+	NoDebug bool
+}
+
+const (
+	minTCBUsage  = "Minimum TCB component versions, e.g. snp=22,microcode=213 (components: bootloader, tee, snp, microcode, tdx_module, tdx_module_major)"
+	noDebugUsage = "Fail verification if the guest policy allows debugging"
+)
+
+// Offsets into the SEV-SNP ATTESTATION_REPORT structure (SEV-SNP ABI
+// specification, table 22).
+const (
+	snpReportSize           = 0x4A0
+	snpPolicyOffset         = 0x08
+	snpPlatformInfoOffset   = 0x40
+	snpReportedTCBOffset    = 0x180
+	snpChipIDOffset         = 0x1A0
+	snpChipIDSize           = 64
+	snpPolicyMigrateMABit   = 18
+	snpPolicyDebugBit       = 19
+	snpPlatformInfoSMTBit   = 0
+	tdxQuoteHeaderSize      = 48
+	tdxTEETCBSVNOffset      = tdxQuoteHeaderSize
+	tdxTDAttributesOffset   = tdxQuoteHeaderSize + 120
+	tdxTDReportBodySize     = 584
+	tdxTDAttributesDebugBit = 0
+)
+
+// parsePlatformReport decodes the raw hardware report in doc. Only SEV-SNP
+// and TDX documents are understood.
+func parsePlatformReport(doc *attestation.Document) (*platformReport, error) {
+	raw, err := decodeDocumentBody(doc.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch platformName(doc.Format) {
+	case "sev-snp":
+		return parseSNPReport(raw)
+	case "tdx":
+		return parseTDXQuote(raw)
+	}
+	return nil, fmt.Errorf("platform details are not available for %s attestations", doc.Format)
+}
+
+// decodeDocumentBody base64-decodes an attestation body and gunzips it if
+// it is compressed.
+func decodeDocumentBody(body string) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return nil, fmt.Errorf("decoding attestation body: %v", err)
+	}
+	if len(raw) > 2 && raw[0] == 0x1f && raw[1] == 0x8b {
+		zr, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, fmt.Errorf("decompressing attestation body: %v", err)
+		}
+		defer zr.Close()
+		if raw, err = io.ReadAll(zr); err != nil {
+			return nil, fmt.Errorf("decompressing attestation body: %v", err)
+		}
+	}
+	return raw, nil
+}
+
+func parseSNPReport(raw []byte) (*platformReport, error) {
+	if len(raw) < snpReportSize {
+		return nil, fmt.Errorf("SEV-SNP report is %d bytes, want %d", len(raw), snpReportSize)
+	}
+	policy := binary.LittleEndian.Uint64(raw[snpPolicyOffset:])
+	platformInfo := binary.LittleEndian.Uint64(raw[snpPlatformInfoOffset:])
+	// TCB_VERSION: boot loader, TEE, 4 reserved bytes, SNP, microcode.
+	tcb := raw[snpReportedTCBOffset : snpReportedTCBOffset+8]
+
+	return &platformReport{
+		Platform: "sev-snp",
+		TCB: map[string]int{
+			"bootloader": int(tcb[0]),
+			"tee":        int(tcb[1]),
+			"snp":        int(tcb[6]),
+			"microcode":  int(tcb[7]),
+		},
+		Debug:     policy&(1<<snpPolicyDebugBit) != 0,
+		Migration: policy&(1<<snpPolicyMigrateMABit) != 0,
+		SMT:       platformInfo&(1<<snpPlatformInfoSMTBit) != 0,
+		Policy:    fmt.Sprintf("%#x", policy),
+		ChipID:    hex.EncodeToString(raw[snpChipIDOffset : snpChipIDOffset+snpChipIDSize]),
+	}, nil
+}
+
+func parseTDXQuote(raw []byte) (*platformReport, error) {
+	if len(raw) < tdxQuoteHeaderSize+tdxTDReportBodySize {
+		return nil, fmt.Errorf("TDX quote is %d bytes, too short for a TD report", len(raw))
+	}
+	svn := raw[tdxTEETCBSVNOffset : tdxTEETCBSVNOffset+16]
+	attrs := binary.LittleEndian.Uint64(raw[tdxTDAttributesOffset:])
+
+	return &platformReport{
+		Platform: "tdx",
+		TCB: map[string]int{
+			"tdx_module":       int(svn[0]),
+			"tdx_module_major": int(svn[1]),
+		},
+		Debug:        attrs&(1<<tdxTDAttributesDebugBit) != 0,
+		TDAttributes: fmt.Sprintf("%#x", attrs),
+		TEETCBSVN:    hex.EncodeToString(svn),
+	}, nil
+}
+
+// parseMinTCB parses --min-tcb values of the form component=version.
+func parseMinTCB(values []string) (map[string]int, error) {
+	known := map[string]bool{}
+	for _, names := range tcbComponents {
+		for _, n := range names {
+			known[n] = true
+		}
+	}
+
+	min := make(map[string]int, len(values))
+	for _, v := range values {
+		name, version, ok := strings.Cut(v, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !known[name] {
+			return nil, fmt.Errorf("invalid --min-tcb %q: expected component=version with component one of %s", v, strings.Join(sortedKeys(known), ", "))
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(version))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid --min-tcb %q: version must be a non-negative integer", v)
+		}
+		min[name] = n
+	}
+	return min, nil
+}
+
+// checkPlatformFlags checks p against --min-tcb and --no-debug. p is nil
+// when the platform details could not be read, which only fails when a
+// requirement is set.
+func checkPlatformFlags(p *platformReport) error {
+	if len(platformRequirements.MinTCB) == 0 && !platformRequirements.NoDebug {
+		return nil
+	}
+	minTCB, err := parseMinTCB(platformRequirements.MinTCB)
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		return fmt.Errorf("platform details are not available to check --min-tcb or --no-debug")
+	}
+	return checkPlatform(p, minTCB, platformRequirements.NoDebug)
+}
+
+// checkPlatform returns an error if p does not meet the minimum TCB or
+// allows debugging when noDebug is set. Components of other platforms in
+// minTCB are ignored.
+func checkPlatform(p *platformReport, minTCB map[string]int, noDebug bool) error {
+	if noDebug && p.Debug {
+		return fmt.Errorf("%s guest policy allows debugging", p.Platform)
+	}
+	for _, name := range tcbComponents[p.Platform] {
+		want, ok := minTCB[name]
+		if !ok {
+			continue
+		}
+		if got := p.TCB[name]; got < want {
+			return fmt.Errorf("%s TCB component %s is %d, below the minimum %d", p.Platform, name, got, want)
+		}
+	}
+	return nil
+}
+
+// printPlatformReport writes p in a human-readable form.
+func printPlatformReport(w io.Writer, p *platformReport) {
+	fmt.Fprintf(w, "Platform:     %s\n", p.Platform)
+	for _, name := range tcbComponents[p.Platform] {
+		fmt.Fprintf(w, "  TCB %-16s %d\n", name+":", p.TCB[name])
+	}
+	fmt.Fprintf(w, "  Debug:       %t\n", p.Debug)
+	if p.Platform == "sev-snp" {
+		fmt.Fprintf(w, "  Migration:   %t\n", p.Migration)
+		fmt.Fprintf(w, "  SMT:         %t\n", p.SMT)
+		fmt.Fprintf(w, "  Policy:      %s\n", p.Policy)
+		fmt.Fprintf(w, "  Chip ID:     %s\n", p.ChipID)
+	}
+	if p.Platform == "tdx" {
+		fmt.Fprintf(w, "  Attributes:  %s\n", p.TDAttributes)
+		fmt.Fprintf(w, "  TEE TCB SVN: %s\n", p.TEETCBSVN)
+	}
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
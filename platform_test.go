@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tinfoilsh/tinfoil-go/verifier/attestation"
+)
+
+func testSNPReport(debug bool) []byte {
+	raw := make([]byte, snpReportSize)
+	policy := uint64(0x30000)
+	if debug {
+		policy |= 1 << snpPolicyDebugBit
+	}
+	binary.LittleEndian.PutUint64(raw[snpPolicyOffset:], policy)
+	binary.LittleEndian.PutUint64(raw[snpPlatformInfoOffset:], 1)
+	copy(raw[snpReportedTCBOffset:], []byte{3, 0, 0, 0, 0, 0, 22, 213})
+	return raw
+}
+
+func TestParseSNPReport(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, err := zw.Write(testSNPReport(true))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	p, err := parsePlatformReport(&attestation.Document{
+		Format: "https://tinfoil.sh/predicate/sev-snp-guest/v2",
+		Body:   base64.StdEncoding.EncodeToString(gz.Bytes()),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "sev-snp", p.Platform)
+	assert.Equal(t, map[string]int{"bootloader": 3, "tee": 0, "snp": 22, "microcode": 213}, p.TCB)
+	assert.True(t, p.Debug)
+	assert.True(t, p.SMT)
+	assert.False(t, p.Migration)
+
+	_, err = parseSNPReport(make([]byte, 100))
+	assert.Error(t, err)
+}
+
+func TestParseTDXQuote(t *testing.T) {
+	raw := make([]byte, tdxQuoteHeaderSize+tdxTDReportBodySize)
+	raw[tdxTEETCBSVNOffset] = 5
+	raw[tdxTEETCBSVNOffset+1] = 1
+	binary.LittleEndian.PutUint64(raw[tdxTDAttributesOffset:], 1<<28)
+
+	p, err := parseTDXQuote(raw)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"tdx_module": 5, "tdx_module_major": 1}, p.TCB)
+	assert.False(t, p.Debug)
+}
+
+func TestCheckPlatform(t *testing.T) {
+	p, err := parseSNPReport(testSNPReport(false))
+	require.NoError(t, err)
+
+	min, err := parseMinTCB([]string{"snp=22", "microcode=213", "tdx_module=9"})
+	require.NoError(t, err)
+	assert.NoError(t, checkPlatform(p, min, true), "other platforms' components are ignored")
+
+	min["microcode"] = 214
+	assert.ErrorContains(t, checkPlatform(p, min, false), "microcode is 213, below the minimum 214")
+
+	p.Debug = true
+	assert.ErrorContains(t, checkPlatform(p, nil, true), "allows debugging")
+
+	_, err = parseMinTCB([]string{"firmware=1"})
+	assert.Error(t, err)
+	_, err = parseMinTCB([]string{"snp=-1"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+
+	"github.com/tinfoilsh/tinfoil-go/verifier/attestation"
+)
+
+// policyPath backs the --policy flag of the commands that verify an enclave.
+var policyPath string
+
+// policy is an allowlist of what an enclave may run. An enclave passes if
+// it meets every requirement and matches at least one allow entry. Policy
+// files are YAML; JSON files parse as YAML too.
+//
+//	allow:
+//	  - name: stable
+//	    repo: tinfoilsh/confidential-model-router
+//	    tag: v0.1.3
+//	  - name: hotfix
+//	    measurement:
+//	      type: https://tinfoil.sh/predicate/sev-snp-guest/v2
+//	      registers: ["..."]
+//	require:
+//	  platforms: [sev-snp, tdx]
+//	  min_tcb: {snp: 22, microcode: 213}
+//	  no_debug: true
+type policy struct {
+	Allow   []policyEntry `yaml:"allow" json:"allow"`
+	Require struct {
+		// Platforms lists the acceptable attestation platforms, either as
+		// short names (sev-snp, tdx, nitro) or full predicate types.
+		Platforms []string `yaml:"platforms" json:"platforms,omitempty"`
+		// MinTCB and NoDebug work like the --min-tcb and --no-debug flags.
+		MinTCB  map[string]int `yaml:"min_tcb" json:"min_tcb,omitempty"`
+		NoDebug bool           `yaml:"no_debug" json:"no_debug,omitempty"`
+	} `yaml:"require" json:"require"`
+
+	path   string
+	sha256 string
+}
+
+// policyEntry allows either the releases of a repo (the latest, or the one
+// selected by Tag or Digest) or a raw measurement.
+type policyEntry struct {
+	Name        string             `yaml:"name" json:"name,omitempty"`
+	Repo        string             `yaml:"repo" json:"repo,omitempty"`
+	Tag         string             `yaml:"tag" json:"tag,omitempty"`
+	Digest      string             `yaml:"digest" json:"digest,omitempty"`
+	Measurement *policyMeasurement `yaml:"measurement" json:"measurement,omitempty"`
+}
+
+type policyMeasurement struct {
+	Type      string   `yaml:"type" json:"type"`
+	Registers []string `yaml:"registers" json:"registers"`
+}
+
+// policyMatch records which policy allowed an enclave.
+type policyMatch struct {
+	File   string `json:"file"`
+	SHA256 string `json:"sha256"`
+	Entry  string `json:"entry,omitempty"`
+}
+
+// loadPolicy reads and validates the policy file at path.
+func loadPolicy(path string) (*policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading policy: %v", err)
+	}
+	return parsePolicy(path, data)
+}
+
+func parsePolicy(path string, data []byte) (*policy, error) {
+	var p policy
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&p); err != nil {
+		return nil, fmt.Errorf("parsing policy %s: %v", path, err)
+	}
+	if len(p.Allow) == 0 {
+		return nil, fmt.Errorf("policy %s has no allow entries", path)
+	}
+	for i := range p.Allow {
+		e := &p.Allow[i]
+		if e.Name == "" {
+			e.Name = fmt.Sprintf("#%d", i+1)
+		}
+		switch {
+		case e.Repo != "" && e.Measurement != nil:
+			return nil, fmt.Errorf("policy entry %s: set either repo or measurement, not both", e.Name)
+		case e.Repo == "" && e.Measurement == nil:
+			return nil, fmt.Errorf("policy entry %s: set repo or measurement", e.Name)
+		case e.Measurement != nil && (e.Tag != "" || e.Digest != ""):
+			return nil, fmt.Errorf("policy entry %s: tag and digest require repo", e.Name)
+		case e.Measurement != nil && (e.Measurement.Type == "" || len(e.Measurement.Registers) == 0):
+			return nil, fmt.Errorf("policy entry %s: measurement needs a type and registers", e.Name)
+		}
+	}
+	for name, version := range p.Require.MinTCB {
+		if _, err := parseMinTCB([]string{fmt.Sprintf("%s=%d", name, version)}); err != nil {
+			return nil, fmt.Errorf("policy %s: %v", path, err)
+		}
+	}
+	p.path = path
+	p.sha256 = sha256Hex(data)
+	return &p, nil
+}
+
+// platformName returns the short platform name for a measurement type.
+func platformName(t attestation.PredicateType) string {
+	s := string(t)
+	switch {
+	case strings.Contains(s, "sev-snp"):
+		return "sev-snp"
+	case strings.Contains(s, "tdx"):
+		return "tdx"
+	case strings.Contains(s, "nitro"):
+		return "nitro"
+	}
+	return s
+}
+
+// checkRequirements returns an error if an enclave with measurement m and
+// platform details pr does not meet the policy's platform requirements. pr
+// is nil when the details could not be read.
+func (p *policy) checkRequirements(m *attestation.Measurement, pr *platformReport) error {
+	if len(p.Require.Platforms) > 0 && !slices.ContainsFunc(p.Require.Platforms, func(want string) bool {
+		return want == string(m.Type) || want == platformName(m.Type)
+	}) {
+		return fmt.Errorf("platform %s is not one of %s", platformName(m.Type), strings.Join(p.Require.Platforms, ", "))
+	}
+	if len(p.Require.MinTCB) == 0 && !p.Require.NoDebug {
+		return nil
+	}
+	if pr == nil {
+		return fmt.Errorf("platform details are not available to check min_tcb or no_debug")
+	}
+	return checkPlatform(pr, p.Require.MinTCB, p.Require.NoDebug)
+}
+
+// verifyWithPolicy verifies the enclave's attestation on its own and then
+// looks for the first allow entry whose measurement equals the enclave's.
+// Repo entries are resolved to a release and checked against Sigstore.
+func verifyWithPolicy(l *log.Logger, opts verifyOptions) (*auditRecord, error) {
+	if opts.Repo != "" || opts.Tag != "" || opts.Digest != "" {
+		err := fmt.Errorf("--policy cannot be combined with --repo, --tag or --digest")
+		return failedRecord(opts.Host, opts.Repo, err), err
+	}
+	p, err := loadPolicy(opts.Policy)
+	if err != nil {
+		return failedRecord(opts.Host, "", err), err
+	}
+
+	ev, err := collectEvidence(l, verifyOptions{Host: opts.Host})
+	if err != nil {
+		return failedRecord(opts.Host, "", err), err
+	}
+	ev.CheckedByPolicy = true
+	record, err := verifyEvidence(l, ev)
+	if err != nil {
+		return record, err
+	}
+	record.Status = ""
+	match := &policyMatch{File: p.path, SHA256: p.sha256}
+	record.Policy = match
+
+	enclaveMeasurement := record.Measurements.Enclave
+	if enclaveMeasurement == nil {
+		record.fail(reasonAttestationInvalid, "attestation has no measurement")
+		return record, record.err()
+	}
+	if err := p.checkRequirements(enclaveMeasurement, record.Platform); err != nil {
+		record.fail(reasonPolicyViolation, err.Error())
+		return record, record.err()
+	}
+
+	var entryErr error
+	for _, e := range p.Allow {
+		if e.Measurement != nil {
+			allowed := &attestation.Measurement{
+				Type:      attestation.PredicateType(e.Measurement.Type),
+				Registers: e.Measurement.Registers,
+			}
+			if allowed.Equals(enclaveMeasurement) == nil {
+				l.Printf("Enclave matches policy entry %s", e.Name)
+				match.Entry = e.Name
+				record.Status = statusOK
+				return record, nil
+			}
+			continue
+		}
+
+		tag, digest, err := resolveRelease(l, e.Repo, e.Tag, e.Digest)
+		if err != nil {
+			l.Warnf("Skipping policy entry %s: %v", e.Name, err)
+			if entryErr == nil {
+				entryErr = err
+			}
+			continue
+		}
+		bundle, trustRoot, err := fetchSigstoreMaterial(l, e.Repo, digest)
+		if err == nil {
+			var code *attestation.Measurement
+			if code, err = verifyCodeMeasurement(l, trustRoot, bundle, e.Repo, digest); err == nil {
+				if code.Equals(enclaveMeasurement) == nil {
+					l.Printf("Enclave matches policy entry %s (%s@%s)", e.Name, e.Repo, tag)
+					match.Entry = e.Name
+					record.Repo, record.Tag, record.Digest = e.Repo, tag, digest
+					record.BundleDigest = sha256Hex(bundle)
+					record.TrustRoot = &trustRootInfo{Source: trustRootSource(), SHA256: sha256Hex(trustRoot)}
+					record.Measurements.Sigstore = *code
+					record.Status = statusOK
+					return record, nil
+				}
+				continue
+			}
+		}
+		l.Warnf("Skipping policy entry %s: %v", e.Name, err)
+		if entryErr == nil {
+			entryErr = err
+		}
+	}
+
+	// Without a match, an entry that could not be checked might have been
+	// the one the enclave runs, so report the error rather than a mismatch.
+	if entryErr != nil {
+		reason := reasonOf(entryErr)
+		if reason == "" {
+			reason = reasonFetchError
+		}
+		record.fail(reason, fmt.Sprintf("no policy entry matched and some could not be checked: %v", entryErr))
+		return record, record.err()
+	}
+	record.fail(reasonMeasurementMismatch, fmt.Sprintf("enclave measurement matches no entry of policy %s", p.path))
+	return record, record.err()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tinfoilsh/tinfoil-go/verifier/attestation"
+)
+
+func TestParsePolicy(t *testing.T) {
+	p, err := parsePolicy("policy.yml", []byte(`
+allow:
+  - name: stable
+    repo: tinfoilsh/confidential-model-router
+    tag: v0.1.3
+  - repo: tinfoilsh/confidential-model-router
+  - name: hotfix
+    measurement:
+      type: https://tinfoil.sh/predicate/sev-snp-guest/v2
+      registers: ["aa"]
+require:
+  platforms: [sev-snp]
+`))
+	require.NoError(t, err)
+	require.Len(t, p.Allow, 3)
+	assert.Equal(t, "stable", p.Allow[0].Name)
+	assert.Equal(t, "v0.1.3", p.Allow[0].Tag)
+	assert.Equal(t, "#2", p.Allow[1].Name)
+	assert.Equal(t, []string{"aa"}, p.Allow[2].Measurement.Registers)
+	assert.Equal(t, []string{"sev-snp"}, p.Require.Platforms)
+	assert.Len(t, p.sha256, 64)
+
+	p, err = parsePolicy("policy.json", []byte(`{"allow": [{"repo": "tinfoilsh/a", "digest": "abc"}]}`))
+	require.NoError(t, err)
+	assert.Equal(t, "abc", p.Allow[0].Digest)
+}
+
+func TestPolicyJSONRoundTrip(t *testing.T) {
+	p, err := parsePolicy("policy.yml", []byte(`
+allow:
+  - name: stable
+    repo: tinfoilsh/a
+    tag: v1
+  - name: hotfix
+    measurement:
+      type: https://tinfoil.sh/predicate/sev-snp-guest/v2
+      registers: ["aa"]
+require:
+  no_debug: true
+`))
+	require.NoError(t, err)
+	data, err := json.Marshal(p)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"measurement":{"type":"https://tinfoil.sh/predicate/sev-snp-guest/v2","registers":["aa"]}`)
+
+	again, err := parsePolicy("policy.json", data)
+	require.NoError(t, err)
+	assert.Equal(t, p.Allow, again.Allow)
+	assert.Equal(t, p.Require, again.Require)
+}
+
+func TestParsePolicyErrors(t *testing.T) {
+	for name, doc := range map[string]string{
+		"no entries":       `allow: []`,
+		"unknown field":    `{"allow": [{"repo": "a/b"}], "deny": []}`,
+		"repo and meas":    `{"allow": [{"repo": "a/b", "measurement": {"type": "t", "registers": ["r"]}}]}`,
+		"empty entry":      `{"allow": [{"name": "x"}]}`,
+		"tag without repo": `{"allow": [{"tag": "v1", "measurement": {"type": "t", "registers": ["r"]}}]}`,
+		"empty registers":  `{"allow": [{"measurement": {"type": "t"}}]}`,
+	} {
+		_, err := parsePolicy("policy", []byte(doc))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestPolicyRequirements(t *testing.T) {
+	p := &policy{}
+	snp := &attestation.Measurement{Type: "https://tinfoil.sh/predicate/sev-snp-guest/v2"}
+	tdx := &attestation.Measurement{Type: "https://tinfoil.sh/predicate/tdx-guest/v2"}
+	assert.NoError(t, p.checkRequirements(tdx, nil))
+
+	p.Require.Platforms = []string{"sev-snp"}
+	assert.NoError(t, p.checkRequirements(snp, nil))
+	assert.Error(t, p.checkRequirements(tdx, nil))
+
+	p.Require.Platforms = []string{string(tdx.Type)}
+	assert.NoError(t, p.checkRequirements(tdx, nil))
+
+	p.Require.NoDebug = true
+	assert.Error(t, p.checkRequirements(tdx, nil), "requirement without platform details")
+	assert.NoError(t, p.checkRequirements(tdx, &platformReport{Platform: "tdx"}))
+	assert.Error(t, p.checkRequirements(tdx, &platformReport{Platform: "tdx", Debug: true}))
+}
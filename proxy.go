@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"net"
 	"net/http"
 	"net/http/httputil"
@@ -23,6 +24,16 @@ func init() {
 	proxyCmd.Flags().UintVarP(&listenPort, "port", "p", 8080, "Port to listen on")
 	proxyCmd.Flags().StringVarP(&listenAddr, "bind", "b", "127.0.0.1", "Address to bind to")
 	proxyCmd.Flags().StringVar(&logFormat, "log-format", "text", "Log format: text or json")
+	proxyCmd.Flags().StringVar(&releaseTag, "tag", "", "Verify against this release tag instead of the latest release")
+	proxyCmd.Flags().StringVar(&releaseDigest, "digest", "", "Verify against this release digest instead of the latest release")
+	proxyCmd.Flags().StringVar(&trustRootPath, "trust-root", "", trustRootUsage)
+	proxyCmd.Flags().StringVar(&policyPath, "policy", "", "Verify against the allowlist in this policy file instead of a single release")
+	proxyCmd.Flags().StringSliceVar(&platformRequirements.MinTCB, "min-tcb", nil, minTCBUsage)
+	proxyCmd.Flags().BoolVar(&platformRequirements.NoDebug, "no-debug", false, noDebugUsage)
+	proxyCmd.Flags().BoolVar(&modeAllowances.Debug, "allow-debug", false, allowDebugUsage)
+	proxyCmd.Flags().BoolVar(&modeAllowances.NonCC, "allow-non-cc", false, allowNonCCUsage)
+	proxyCmd.Flags().BoolVar(&allowNoHPKE, "allow-no-hpke", false, allowNoHPKEUsage)
+	proxyCmd.Flags().StringVar(&knownEnclavesMode, "known-enclaves", knownEnclavesWarn, knownEnclavesUsage)
 }
 
 func setupLogger(verbose, trace bool) {
@@ -48,25 +59,64 @@ var proxyCmd = &cobra.Command{
 		trace, _ := cmd.Flags().GetBool("trace")
 		setupLogger(verbose, trace)
 
+		if err := validateKnownEnclavesMode(); err != nil {
+			return err
+		}
+		if _, err := parseMinTCB(platformRequirements.MinTCB); err != nil {
+			return err
+		}
+
 		log.WithFields(log.Fields{
 			"enclave_host": enclaveHost,
 			"repo":         repo,
 		}).Info("initializing secure client")
 
-		var tinfoilClient *tinfoil.Client
-		var err error
-		if enclaveHost == "" && repo == "" {
-			tinfoilClient, err = tinfoil.NewClient()
-			if err == nil {
-				enclaveHost = tinfoilClient.Enclave()
-				repo = tinfoilClient.Repo()
+		var httpClient *http.Client
+		if !useLibraryClient() {
+			if customVerification() && policyPath == "" && (enclaveHost == "" || repo == "") {
+				return fmt.Errorf("--tag, --digest, --trust-root, --min-tcb, --no-debug and a TUF mirror require both --host and --repo")
+			}
+			opts, err := pinnedVerifyOptions()
+			if err != nil {
+				log.WithError(err).Error("failed to select enclave")
+				return err
+			}
+			pinnedClient, record, err := newPinnedHTTPClient(log.StandardLogger(), opts)
+			if err != nil {
+				log.WithError(err).Error("failed to verify enclave")
+				return err
+			}
+			fields := log.Fields{
+				"tag":    record.Tag,
+				"digest": record.Digest,
 			}
+			if record.Policy != nil {
+				fields["policy_entry"] = record.Policy.Entry
+			}
+			log.WithFields(fields).Info("enclave verified against pinned release")
+			if err := checkKnownEnclave(log.StandardLogger(), record); err != nil {
+				log.WithError(err).Error("refusing enclave")
+				return err
+			}
+			enclaveHost = record.Enclave
+			httpClient = pinnedClient
 		} else {
-			tinfoilClient, err = tinfoil.NewClientWithParams(enclaveHost, repo)
-		}
-		if err != nil {
-			log.WithError(err).Error("failed to create HTTP client")
-			return err
+			var tinfoilClient *tinfoil.Client
+			var err error
+			if enclaveHost == "" && repo == "" {
+				tinfoilClient, err = tinfoil.NewClient()
+				if err == nil {
+					enclaveHost = tinfoilClient.Enclave()
+					repo = tinfoilClient.Repo()
+				}
+			} else {
+				tinfoilClient, err = tinfoil.NewClientWithParams(enclaveHost, repo)
+			}
+			if err != nil {
+				log.WithError(err).Error("failed to create HTTP client")
+				return err
+			}
+			httpClient = tinfoilClient.HTTPClient()
 		}
 		log.Debug("secure HTTP client created successfully")
 
@@ -76,8 +126,6 @@ var proxyCmd = &cobra.Command{
 			return err
 		}
 
-		httpClient := tinfoilClient.HTTPClient()
-
 		proxy := httputil.NewSingleHostReverseProxy(targetUrl)
 		proxy.Transport = withLoggingTransport(log.StandardLogger(), httpClient.Transport)
 
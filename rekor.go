@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	tlog "github.com/transparency-dev/formats/log"
+	"github.com/transparency-dev/merkle/proof"
+	"github.com/transparency-dev/merkle/rfc6962"
+)
+
+// rekorOptions back the --rekor and --rekor-checkpoint flags.
+var rekorOptions struct {
+	Show       bool
+	Checkpoint string
+}
+
+const (
+	rekorUsage           = "Record the release's Rekor transparency log entry and verify its inclusion proof"
+	rekorCheckpointUsage = "Also check the Rekor inclusion proof against this saved log checkpoint (implies --rekor)"
+
+	fetchRekorCheckpointUsage = "Also save the Rekor consistency proof to this log checkpoint, for replaying with --offline --rekor-checkpoint"
+)
+
+// rekorEntry describes the transparency log entry of a Sigstore bundle.
+type rekorEntry struct {
+	LogIndex       int64  `json:"log_index"`
+	LogID          string `json:"log_id,omitempty"`
+	IntegratedTime string `json:"integrated_time"`
+	URL            string `json:"url"`
+
+	// Inclusion proof of the entry in the log tree of TreeSize leaves.
+	TreeIndex int64  `json:"tree_index"`
+	TreeSize  int64  `json:"tree_size"`
+	RootHash  string `json:"root_hash"`
+
+	Checkpoint *rekorCheckpointCheck `json:"checkpoint,omitempty"`
+}
+
+// rekorCheckpointCheck records the saved checkpoint the inclusion proof was
+// checked against. Its signature is not verified, which Signature records.
+type rekorCheckpointCheck struct {
+	Origin    string `json:"origin"`
+	TreeSize  int64  `json:"tree_size"`
+	RootHash  string `json:"root_hash"`
+	Signature string `json:"signature"`
+}
+
+// checkpointSignatureUnverified is the rekorCheckpointCheck.Signature of
+// every saved checkpoint: it is trusted as given by the user.
+const checkpointSignatureUnverified = "unverified"
+
+// bundleTlogEntry is the part of a Sigstore bundle's transparency log entry
+// that we use. Integers are encoded as strings in protobuf JSON.
+type bundleTlogEntry struct {
+	LogIndex string `json:"logIndex"`
+	LogID    struct {
+		KeyID string `json:"keyId"`
+	} `json:"logId"`
+	IntegratedTime    string `json:"integratedTime"`
+	CanonicalizedBody string `json:"canonicalizedBody"`
+	InclusionProof    *struct {
+		LogIndex   string   `json:"logIndex"`
+		RootHash   string   `json:"rootHash"`
+		TreeSize   string   `json:"treeSize"`
+		Hashes     []string `json:"hashes"`
+		Checkpoint struct {
+			Envelope string `json:"envelope"`
+		} `json:"checkpoint"`
+	} `json:"inclusionProof"`
+}
+
+// rekorCheckpoint is a parsed log checkpoint (a signed tree head in the
+// transparency-dev checkpoint format).
+type rekorCheckpoint struct {
+	Origin   string
+	TreeSize int64
+	RootHash []byte
+}
+
+// rekorProof is the inclusion proof extracted from a bundle.
+type rekorProof struct {
+	Entry    rekorEntry
+	LeafHash []byte
+	Hashes   [][]byte
+	Root     []byte
+	// Checkpoint is the checkpoint embedded in the bundle, if any.
+	Checkpoint *rekorCheckpoint
+}
+
+// consistencyProof shows that a log of FirstSize leaves is a prefix of one
+// of SecondSize leaves.
+type consistencyProof struct {
+	FirstSize  int64    `json:"first_size"`
+	SecondSize int64    `json:"second_size"`
+	Hashes     [][]byte `json:"hashes"`
+}
+
+// rekorEnabled reports whether --rekor or --rekor-checkpoint was given.
+func rekorEnabled() bool {
+	return rekorOptions.Show || rekorOptions.Checkpoint != ""
+}
+
+// verifyRekorEntry checks the inclusion proof in ev's bundle and, with
+// --rekor-checkpoint, its consistency with the saved checkpoint.
+func verifyRekorEntry(l *log.Logger, ev *evidence) (*rekorEntry, error) {
+	l.Println("Verifying Rekor inclusion proof")
+	p, err := parseBundleRekorProof(ev.Bundle)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.verify(); err != nil {
+		return nil, err
+	}
+	l.Printf("Rekor log index %d, integrated at %s", p.Entry.LogIndex, p.Entry.IntegratedTime)
+
+	if rekorOptions.Checkpoint != "" {
+		saved, err := readRekorCheckpoint(rekorOptions.Checkpoint)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.checkAgainst(saved, ev.RekorConsistency); err != nil {
+			return nil, err
+		}
+		l.Printf("Inclusion proof is consistent with the saved checkpoint at tree size %d (checkpoint signature not verified)", saved.TreeSize)
+		p.Entry.Checkpoint = &rekorCheckpointCheck{
+			Origin:    saved.Origin,
+			TreeSize:  saved.TreeSize,
+			RootHash:  hex.EncodeToString(saved.RootHash),
+			Signature: checkpointSignatureUnverified,
+		}
+	}
+	return &p.Entry, nil
+}
+
+// fetchRekorConsistency fetches the consistency proof between the tree the
+// bundle's entry was proven in and the --rekor-checkpoint tree, or returns
+// nil if both are the same size. The proof is fetched from the log in
+// trustRoot that the checkpoint belongs to.
+func fetchRekorConsistency(l *log.Logger, bundle, trustRoot []byte) (*consistencyProof, error) {
+	p, err := parseBundleRekorProof(bundle)
+	if err != nil {
+		return nil, verifyErrorf(reasonSigstoreError, "%v", err)
+	}
+	saved, err := readRekorCheckpoint(rekorOptions.Checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	if saved.TreeSize == p.Entry.TreeSize {
+		return nil, nil
+	}
+	logURL, err := rekorLogURL(trustRoot, saved.Origin)
+	if err != nil {
+		return nil, verifyErrorf(reasonSigstoreError, "%v", err)
+	}
+	first, second := min(saved.TreeSize, p.Entry.TreeSize), max(saved.TreeSize, p.Entry.TreeSize)
+	l.Printf("Fetching Rekor consistency proof between tree sizes %d and %d from %s", first, second, logURL)
+	cp, err := fetchConsistencyProof(logURL, saved.Origin, first, second)
+	if err != nil {
+		return nil, verifyErrorf(reasonFetchError, "fetching rekor consistency proof: %v", err)
+	}
+	return cp, nil
+}
+
+// rekorLogURL returns the base URL of the transparency log in trustRoot
+// whose host names the checkpoint origin ("rekor.sigstore.dev - <tree ID>"),
+// or of its only log.
+func rekorLogURL(trustRoot []byte, origin string) (string, error) {
+	var root struct {
+		Tlogs []struct {
+			BaseURL string `json:"baseUrl"`
+		} `json:"tlogs"`
+	}
+	if err := json.Unmarshal(trustRoot, &root); err != nil {
+		return "", fmt.Errorf("parsing trust root: %v", err)
+	}
+	host, _, _ := strings.Cut(origin, " - ")
+	for _, t := range root.Tlogs {
+		if u, err := url.Parse(t.BaseURL); err == nil && strings.EqualFold(u.Host, strings.TrimSpace(host)) {
+			return strings.TrimRight(t.BaseURL, "/"), nil
+		}
+	}
+	if len(root.Tlogs) == 1 && root.Tlogs[0].BaseURL != "" {
+		return strings.TrimRight(root.Tlogs[0].BaseURL, "/"), nil
+	}
+	return "", fmt.Errorf("trust root has no transparency log for checkpoint origin %q", origin)
+}
+
+// parseBundleRekorProof extracts the first transparency log entry of a
+// Sigstore bundle and its inclusion proof.
+func parseBundleRekorProof(bundle []byte) (*rekorProof, error) {
+	var b struct {
+		VerificationMaterial struct {
+			TlogEntries []bundleTlogEntry `json:"tlogEntries"`
+		} `json:"verificationMaterial"`
+	}
+	if err := json.Unmarshal(bundle, &b); err != nil {
+		return nil, fmt.Errorf("parsing bundle: %v", err)
+	}
+	if len(b.VerificationMaterial.TlogEntries) == 0 {
+		return nil, fmt.Errorf("bundle has no transparency log entries")
+	}
+	e := b.VerificationMaterial.TlogEntries[0]
+	if e.InclusionProof == nil {
+		return nil, fmt.Errorf("bundle's transparency log entry has no inclusion proof")
+	}
+
+	p := &rekorProof{}
+	var err error
+	if p.Entry.LogIndex, err = strconv.ParseInt(e.LogIndex, 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid log index %q", e.LogIndex)
+	}
+	integrated, err := strconv.ParseInt(e.IntegratedTime, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid integrated time %q", e.IntegratedTime)
+	}
+	p.Entry.IntegratedTime = time.Unix(integrated, 0).UTC().Format(time.RFC3339)
+	if id, err := base64.StdEncoding.DecodeString(e.LogID.KeyID); err == nil {
+		p.Entry.LogID = hex.EncodeToString(id)
+	}
+	p.Entry.URL = fmt.Sprintf("https://search.sigstore.dev/?logIndex=%d", p.Entry.LogIndex)
+
+	ip := e.InclusionProof
+	if p.Entry.TreeIndex, err = strconv.ParseInt(ip.LogIndex, 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid inclusion proof index %q", ip.LogIndex)
+	}
+	if p.Entry.TreeSize, err = strconv.ParseInt(ip.TreeSize, 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid inclusion proof tree size %q", ip.TreeSize)
+	}
+	if p.Root, err = base64.StdEncoding.DecodeString(ip.RootHash); err != nil {
+		return nil, fmt.Errorf("invalid inclusion proof root hash: %v", err)
+	}
+	p.Entry.RootHash = hex.EncodeToString(p.Root)
+	for _, h := range ip.Hashes {
+		b, err := base64.StdEncoding.DecodeString(h)
+		if err != nil {
+			return nil, fmt.Errorf("invalid inclusion proof hash: %v", err)
+		}
+		p.Hashes = append(p.Hashes, b)
+	}
+	body, err := base64.StdEncoding.DecodeString(e.CanonicalizedBody)
+	if err != nil {
+		return nil, fmt.Errorf("invalid canonicalized body: %v", err)
+	}
+	p.LeafHash = rfc6962.DefaultHasher.HashLeaf(body)
+	if ip.Checkpoint.Envelope != "" {
+		if p.Checkpoint, err = parseRekorCheckpoint([]byte(ip.Checkpoint.Envelope)); err != nil {
+			return nil, fmt.Errorf("bundle checkpoint: %v", err)
+		}
+	}
+	return p, nil
+}
+
+// verify checks the inclusion proof against its root hash and, if the
+// bundle carries one, its embedded checkpoint.
+func (p *rekorProof) verify() error {
+	if err := proof.VerifyInclusion(rfc6962.DefaultHasher, uint64(p.Entry.TreeIndex), uint64(p.Entry.TreeSize), p.LeafHash, p.Hashes, p.Root); err != nil {
+		return fmt.Errorf("rekor inclusion proof: %v", err)
+	}
+	if c := p.Checkpoint; c != nil && (c.TreeSize != p.Entry.TreeSize || !bytes.Equal(c.RootHash, p.Root)) {
+		return fmt.Errorf("rekor inclusion proof does not match the bundle's checkpoint")
+	}
+	return nil
+}
+
+// checkAgainst verifies that the tree the entry was proven in is consistent
+// with the saved checkpoint. A consistency proof is needed unless both have
+// the same size.
+func (p *rekorProof) checkAgainst(saved *rekorCheckpoint, consistency *consistencyProof) error {
+	size := p.Entry.TreeSize
+	if saved.TreeSize == size {
+		if !bytes.Equal(saved.RootHash, p.Root) {
+			return fmt.Errorf("saved checkpoint root %x differs from the inclusion proof root %x at tree size %d", saved.RootHash, p.Root, size)
+		}
+		return nil
+	}
+	first, firstRoot, second, secondRoot := saved.TreeSize, saved.RootHash, size, p.Root
+	if first > second {
+		first, firstRoot, second, secondRoot = second, secondRoot, first, firstRoot
+	}
+	if consistency == nil || consistency.FirstSize != first || consistency.SecondSize != second {
+		return fmt.Errorf("no consistency proof between tree sizes %d and %d", first, second)
+	}
+	if err := proof.VerifyConsistency(rfc6962.DefaultHasher, uint64(first), uint64(second), consistency.Hashes, firstRoot, secondRoot); err != nil {
+		return fmt.Errorf("saved checkpoint is not consistent with the inclusion proof: %v", err)
+	}
+	return nil
+}
+
+// parseRekorCheckpoint parses the body of a checkpoint: the log origin,
+// tree size and root hash. Signatures are not checked; the saved checkpoint
+// is trusted by whoever saved it, and records mark it as unverified.
+func parseRekorCheckpoint(data []byte) (*rekorCheckpoint, error) {
+	var c tlog.Checkpoint
+	if _, err := c.Unmarshal(data); err != nil {
+		return nil, err
+	}
+	if c.Size == 0 {
+		return nil, fmt.Errorf("invalid checkpoint tree size 0")
+	}
+	if len(c.Hash) != sha256.Size {
+		return nil, fmt.Errorf("invalid checkpoint root hash length %d", len(c.Hash))
+	}
+	return &rekorCheckpoint{Origin: c.Origin, TreeSize: int64(c.Size), RootHash: c.Hash}, nil
+}
+
+func readRekorCheckpoint(path string) (*rekorCheckpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading rekor checkpoint: %v", err)
+	}
+	c, err := parseRekorCheckpoint(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing rekor checkpoint %s: %v", path, err)
+	}
+	return c, nil
+}
+
+// fetchConsistencyProof asks the Rekor instance at logURL for a proof that
+// the log at size first is a prefix of the log at size second. The tree ID
+// is taken from the checkpoint origin ("rekor.sigstore.dev - <tree ID>")
+// when present.
+func fetchConsistencyProof(logURL, origin string, first, second int64) (*consistencyProof, error) {
+	q := url.Values{}
+	q.Set("firstSize", strconv.FormatInt(first, 10))
+	q.Set("lastSize", strconv.FormatInt(second, 10))
+	if _, treeID, ok := strings.Cut(origin, " - "); ok {
+		q.Set("treeID", strings.TrimSpace(treeID))
+	}
+
+	resp, err := enclaveHTTP.Get(logURL + "/api/v1/log/proof?" + q.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("fetching consistency proof: %s", resp.Status)
+	}
+	var body struct {
+		Hashes []string `json:"hashes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decoding consistency proof: %v", err)
+	}
+	cp := &consistencyProof{FirstSize: first, SecondSize: second}
+	for _, h := range body.Hashes {
+		b, err := hex.DecodeString(h)
+		if err != nil {
+			return nil, fmt.Errorf("decoding consistency proof: %v", err)
+		}
+		cp.Hashes = append(cp.Hashes, b)
+	}
+	return cp, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/transparency-dev/merkle/rfc6962"
+	"github.com/transparency-dev/merkle/testonly"
+)
+
+func testTree(n int) *testonly.Tree {
+	tree := testonly.New(rfc6962.DefaultHasher)
+	for i := 0; i < n; i++ {
+		tree.AppendData([]byte(fmt.Sprintf("leaf %d", i)))
+	}
+	return tree
+}
+
+func testBundle(t *testing.T, tree *testonly.Tree, index, size uint64) []byte {
+	t.Helper()
+	path, err := tree.InclusionProof(index, size)
+	require.NoError(t, err)
+	var hashes []string
+	for _, h := range path {
+		hashes = append(hashes, base64.StdEncoding.EncodeToString(h))
+	}
+	root := tree.HashAt(size)
+	checkpoint := fmt.Sprintf("rekor.sigstore.dev - 1\n%d\n%s\n\n— sig\n", size, base64.StdEncoding.EncodeToString(root))
+	entry := map[string]any{
+		"logIndex":          "1000",
+		"logId":             map[string]string{"keyId": base64.StdEncoding.EncodeToString([]byte{0xc0, 0xd2})},
+		"integratedTime":    "1700000000",
+		"canonicalizedBody": base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("leaf %d", index))),
+		"inclusionProof": map[string]any{
+			"logIndex":   fmt.Sprint(index),
+			"rootHash":   base64.StdEncoding.EncodeToString(root),
+			"treeSize":   fmt.Sprint(size),
+			"hashes":     hashes,
+			"checkpoint": map[string]string{"envelope": checkpoint},
+		},
+	}
+	data, err := json.Marshal(map[string]any{
+		"verificationMaterial": map[string]any{"tlogEntries": []any{entry}},
+	})
+	require.NoError(t, err)
+	return data
+}
+
+func TestBundleRekorProof(t *testing.T) {
+	tree := testTree(11)
+	p, err := parseBundleRekorProof(testBundle(t, tree, 6, 9))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1000), p.Entry.LogIndex)
+	assert.Equal(t, "2023-11-14T22:13:20Z", p.Entry.IntegratedTime)
+	assert.Equal(t, "c0d2", p.Entry.LogID)
+	assert.Equal(t, int64(9), p.Entry.TreeSize)
+	require.NoError(t, p.verify())
+
+	same := &rekorCheckpoint{TreeSize: 9, RootHash: tree.HashAt(9)}
+	assert.NoError(t, p.checkAgainst(same, nil))
+
+	later := &rekorCheckpoint{TreeSize: 11, RootHash: tree.HashAt(11)}
+	assert.Error(t, p.checkAgainst(later, nil), "needs a consistency proof")
+	hashes, err := tree.ConsistencyProof(9, 11)
+	require.NoError(t, err)
+	cp := &consistencyProof{FirstSize: 9, SecondSize: 11, Hashes: hashes}
+	assert.NoError(t, p.checkAgainst(later, cp))
+
+	forked := &rekorCheckpoint{TreeSize: 11, RootHash: testTree(12).HashAt(12)}
+	assert.Error(t, p.checkAgainst(forked, cp))
+
+	p.Hashes = p.Hashes[1:]
+	assert.Error(t, p.verify())
+}
+
+func TestParseRekorCheckpoint(t *testing.T) {
+	root := testTree(2).Hash()
+	c, err := parseRekorCheckpoint([]byte("rekor.sigstore.dev - 42\n2\n" + base64.StdEncoding.EncodeToString(root) + "\n\n— rekor sig\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "rekor.sigstore.dev - 42", c.Origin)
+	assert.Equal(t, int64(2), c.TreeSize)
+	assert.Equal(t, root, c.RootHash)
+
+	_, err = parseRekorCheckpoint([]byte("origin\nnot-a-number\nAAAA\n"))
+	assert.Error(t, err)
+	_, err = parseRekorCheckpoint([]byte("origin\n2\nAAAA\n"))
+	assert.ErrorContains(t, err, "root hash length")
+}
+
+func TestRekorLogURL(t *testing.T) {
+	root := []byte(`{"tlogs":[{"baseUrl":"https://rekor.sigstore.dev"},{"baseUrl":"https://rekor.example.com/"}]}`)
+	u, err := rekorLogURL(root, "rekor.example.com - 42")
+	require.NoError(t, err)
+	assert.Equal(t, "https://rekor.example.com", u)
+
+	_, err = rekorLogURL(root, "other.example.com - 1")
+	assert.ErrorContains(t, err, "no transparency log")
+
+	u, err = rekorLogURL([]byte(`{"tlogs":[{"baseUrl":"https://rekor.internal"}]}`), "rekor.internal.example - 7")
+	require.NoError(t, err)
+	assert.Equal(t, "https://rekor.internal", u, "a single log is used whatever its origin")
+}
+
+func TestFetchConsistencyProofUsesLogURL(t *testing.T) {
+	var gotQuery url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/log/proof", r.URL.Path)
+		gotQuery = r.URL.Query()
+		w.Write([]byte(`{"hashes":["` + strings.Repeat("ab", 32) + `"]}`))
+	}))
+	defer srv.Close()
+
+	cp, err := fetchConsistencyProof(srv.URL, "rekor.example.com - 42", 3, 8)
+	require.NoError(t, err)
+	assert.Equal(t, "42", gotQuery.Get("treeID"))
+	assert.Equal(t, "3", gotQuery.Get("firstSize"))
+	assert.Equal(t, int64(8), cp.SecondSize)
+	assert.Len(t, cp.Hashes, 1)
+}
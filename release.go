@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	releaseDigestPattern = regexp.MustCompile("Digest: `([a-fA-F0-9]{64})`")
+	// Older releases published the EIF hash instead of a digest line.
+	releaseEIFPattern = regexp.MustCompile(`EIF hash: ([a-fA-F0-9]{64})`)
+)
+
+var githubHTTP = &http.Client{Timeout: 30 * time.Second, Transport: verifierTransport}
+
+// fetchRelease resolves a release of repo to its tag and attestation digest.
+// An empty tag selects the latest release. Results are cached briefly, since
+// the latest release and (rarely) a tag can move.
+func fetchRelease(repo, tag string) (string, string, error) {
+	ttl, maxStale := cacheTTLTaggedRelease, cacheMaxStaleTaggedRelease
+	if tag == "" {
+		ttl, maxStale = cacheTTLLatestRelease, 0
+	}
+	key := fmt.Sprintf("%s/%s@%s", currentGitHub().APIURL, repo, tag)
+	data, err := cachedFetch(cacheKindRelease, key, ttl, maxStale, func() ([]byte, error) {
+		resolvedTag, digest, err := lookupRelease(repo, tag)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(cachedRelease{Tag: resolvedTag, Digest: digest})
+	})
+	if err != nil {
+		return "", "", err
+	}
+	var rel cachedRelease
+	if err := json.Unmarshal(data, &rel); err != nil {
+		return "", "", fmt.Errorf("decoding cached release: %w", err)
+	}
+	return rel.Tag, rel.Digest, nil
+}
+
+type cachedRelease struct {
+	Tag    string `json:"tag"`
+	Digest string `json:"digest"`
+}
+
+// githubRelease is the part of a GitHub release the CLI reads.
+type githubRelease struct {
+	TagName     string `json:"tag_name"`
+	Body        string `json:"body"`
+	PublishedAt string `json:"published_at"`
+}
+
+// lookupRelease resolves a release of repo, the latest one if tag is empty.
+// The tag and the digest come from the same authenticated response, so they
+// always belong to the same release.
+func lookupRelease(repo, tag string) (string, string, error) {
+	path := fmt.Sprintf("/repos/%s/releases/latest", repo)
+	if tag != "" {
+		path = fmt.Sprintf("/repos/%s/releases/tags/%s", repo, url.PathEscape(tag))
+	}
+	var rel githubRelease
+	if err := githubGetJSON(currentGitHub().APIURL+path, &rel); err != nil {
+		return "", "", err
+	}
+	digest, err := digestOfRelease(repo, rel)
+	if err != nil {
+		return "", "", err
+	}
+	return rel.TagName, digest, nil
+}
+
+// listReleases returns up to limit of the most recent releases of repo,
+// reading as many pages of GitHub's list (at most 100 per page) as needed.
+func listReleases(repo string, limit int) ([]githubRelease, error) {
+	perPage := min(limit, 100)
+	var releases []githubRelease
+	for page := 1; len(releases) < limit; page++ {
+		var batch []githubRelease
+		url := fmt.Sprintf("%s/repos/%s/releases?per_page=%d&page=%d", currentGitHub().APIURL, repo, perPage, page)
+		if err := githubGetJSON(url, &batch); err != nil {
+			return nil, err
+		}
+		releases = append(releases, batch...)
+		if len(batch) < perPage {
+			break
+		}
+	}
+	if len(releases) > limit {
+		releases = releases[:limit]
+	}
+	return releases, nil
+}
+
+// digestOfRelease returns the attestation digest published with rel.
+func digestOfRelease(repo string, rel githubRelease) (string, error) {
+	for _, re := range []*regexp.Regexp{releaseDigestPattern, releaseEIFPattern} {
+		if m := re.FindStringSubmatch(rel.Body); m != nil {
+			return strings.ToLower(m[1]), nil
+		}
+	}
+
+	// Newer releases attach the digest as a release asset instead.
+	body, err := githubGet(fmt.Sprintf("%s/%s/releases/download/%s/tinfoil.hash", currentGitHub().URL, repo, url.PathEscape(rel.TagName)))
+	if err != nil {
+		return "", fmt.Errorf("release %s has no digest: %w", rel.TagName, err)
+	}
+	digest, err := normalizeDigest(string(body))
+	if err != nil {
+		return "", fmt.Errorf("release %s: %w", rel.TagName, err)
+	}
+	return digest, nil
+}
+
+// normalizeDigest accepts a hex sha256 digest with an optional "sha256:"
+// prefix and returns it lowercased without the prefix.
+func normalizeDigest(s string) (string, error) {
+	d := strings.ToLower(strings.TrimSpace(s))
+	d = strings.TrimPrefix(d, "sha256:")
+	if len(d) != 64 {
+		return "", fmt.Errorf("invalid digest %q: expected 64 hex characters", s)
+	}
+	for _, ch := range d {
+		if !((ch >= '0' && ch <= '9') || (ch >= 'a' && ch <= 'f')) {
+			return "", fmt.Errorf("invalid digest %q: expected 64 hex characters", s)
+		}
+	}
+	return d, nil
+}
+
+func githubGetJSON(url string, out any) error {
+	body, err := githubGet(url)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("decoding %s: %w", url, err)
+	}
+	return nil
+}
+
+func githubGet(url string) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("building request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	gh := currentGitHub()
+	if gh.Token != "" && (strings.HasPrefix(url, gh.APIURL+"/") || strings.HasPrefix(url, gh.URL+"/")) {
+		req.Header.Set("Authorization", "Bearer "+gh.Token)
+	}
+
+	resp, err := githubHTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("GET %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", url, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if resp.Header.Get("X-RateLimit-Remaining") == "0" && gh.Token == "" {
+			return nil, fmt.Errorf("GET %s: GitHub rate limit exceeded; set GITHUB_TOKEN to authenticate", url)
+		}
+		return nil, fmt.Errorf("GET %s: %d: %s", url, resp.StatusCode, extractErrorMessage(body))
+	}
+	return body, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeDigest(t *testing.T) {
+	want := strings.Repeat("ab", 32)
+
+	for _, in := range []string{want, strings.ToUpper(want), "sha256:" + want, " " + want + "\n"} {
+		got, err := normalizeDigest(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got)
+	}
+
+	for _, in := range []string{"", "abc", strings.Repeat("zz", 32), "sha512:" + want} {
+		_, err := normalizeDigest(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestResolveReleasePinnedDigestSkipsLookup(t *testing.T) {
+	digest := strings.Repeat("0f", 32)
+	tag, got, err := resolveRelease(discardLogger(), "tinfoilsh/confidential-model-router", "", "sha256:"+digest)
+	require.NoError(t, err)
+	assert.Empty(t, tag)
+	assert.Equal(t, digest, got)
+}
+
+func TestLookupReleaseEscapesTag(t *testing.T) {
+	digest := strings.Repeat("cd", 32)
+	var gotPath string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.EscapedPath()
+		w.Write([]byte(`{"tag_name":"v1/../x","body":"Digest: ` + "`" + digest + "`" + `"}`))
+	}))
+	defer srv.Close()
+	useGitHub(t, githubSettings{APIURL: srv.URL, URL: srv.URL})
+
+	tag, got, err := lookupRelease("acme/app", "v1/../x")
+	require.NoError(t, err)
+	assert.Equal(t, "/repos/acme/app/releases/tags/v1%2F..%2Fx", gotPath)
+	assert.Equal(t, "v1/../x", tag)
+	assert.Equal(t, digest, got)
+}
+
+func TestLookupLatestReleaseUsesOneAuthenticatedResponse(t *testing.T) {
+	digest := strings.Repeat("ef", 32)
+	var gotPath, gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotAuth = r.URL.Path, r.Header.Get("Authorization")
+		w.Write([]byte(`{"tag_name":"v2","body":"Digest: ` + "`" + digest + "`" + `"}`))
+	}))
+	defer srv.Close()
+	useGitHub(t, githubSettings{APIURL: srv.URL, URL: srv.URL, Token: "test-token"})
+
+	tag, got, err := lookupRelease("acme/app", "")
+	require.NoError(t, err)
+	assert.Equal(t, "/repos/acme/app/releases/latest", gotPath)
+	assert.Contains(t, gotAuth, "test-token")
+	assert.Equal(t, "v2", tag)
+	assert.Equal(t, digest, got)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+// Signed reports wrap an auditRecord in a DSSE envelope
+// (https://github.com/secure-systems-lab/dsse) signed with an ed25519 key.
+
+const reportPayloadType = "application/vnd.tinfoil.verification-report+json"
+
+var (
+	signKeyPath   string
+	reportKeyPath string
+)
+
+func init() {
+	attestationCmd.AddCommand(attestationReportCmd)
+	attestationReportCmd.AddCommand(attestationReportVerifyCmd)
+	attestationReportVerifyCmd.Flags().StringVarP(&reportKeyPath, "key", "k", "", "PEM public key of the expected signer [required]")
+	_ = attestationReportVerifyCmd.MarkFlagRequired("key")
+}
+
+type dsseEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     string          `json:"payload"`
+	Signatures  []dsseSignature `json:"signatures"`
+}
+
+type dsseSignature struct {
+	KeyID string `json:"keyid,omitempty"`
+	Sig   string `json:"sig"`
+}
+
+var attestationReportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Signed verification report commands",
+}
+
+var attestationReportVerifyCmd = &cobra.Command{
+	Use:   "verify <report>",
+	Short: "Check the signature on a verification report",
+	Long: `Check that a report written by ` + "`attestation verify --sign-key`" + ` was signed by
+the given public key and print the verification record or in-toto statement
+it contains.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pub, err := readPublicKey(reportKeyPath)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return fmt.Errorf("reading report: %v", err)
+		}
+		payload, err := verifyReport(data, pub)
+		if err != nil {
+			return err
+		}
+
+		var output bytes.Buffer
+		if err := json.Indent(&output, payload, "", "  "); err != nil {
+			return fmt.Errorf("parsing report payload: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "Signature valid: signed by key %s\n", keyID(pub))
+		fmt.Println(output.String())
+		return nil
+	},
+}
+
+// signEnvelope wraps payload in a DSSE envelope signed with key.
+func signEnvelope(payloadType string, payload []byte, key ed25519.PrivateKey) *dsseEnvelope {
+	sig := ed25519.Sign(key, dssePAE(payloadType, payload))
+	return &dsseEnvelope{
+		PayloadType: payloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures: []dsseSignature{{
+			KeyID: keyID(key.Public().(ed25519.PublicKey)),
+			Sig:   base64.StdEncoding.EncodeToString(sig),
+		}},
+	}
+}
+
+// verifyReport checks that the envelope in data carries a valid signature
+// from pub over a verification record or in-toto statement and returns the
+// signed payload.
+func verifyReport(data []byte, pub ed25519.PublicKey) ([]byte, error) {
+	payloadType, payload, err := verifyEnvelope(data, pub)
+	if err != nil {
+		return nil, err
+	}
+	if payloadType != reportPayloadType && payloadType != inTotoPayloadType {
+		return nil, fmt.Errorf("unexpected payload type %q", payloadType)
+	}
+	return payload, nil
+}
+
+// verifyEnvelope checks that the DSSE envelope in data carries a valid
+// signature from pub and returns its payload type and payload.
+func verifyEnvelope(data []byte, pub ed25519.PublicKey) (string, []byte, error) {
+	var env dsseEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return "", nil, fmt.Errorf("parsing report: %v", err)
+	}
+	payload, err := base64.StdEncoding.DecodeString(env.Payload)
+	if err != nil {
+		return "", nil, fmt.Errorf("decoding report payload: %v", err)
+	}
+
+	pae := dssePAE(env.PayloadType, payload)
+	for _, s := range env.Signatures {
+		sig, err := base64.StdEncoding.DecodeString(s.Sig)
+		if err != nil {
+			continue
+		}
+		if ed25519.Verify(pub, pae, sig) {
+			return env.PayloadType, payload, nil
+		}
+	}
+	return "", nil, fmt.Errorf("report is not signed by key %s", keyID(pub))
+}
+
+// dssePAE is the DSSE pre-authentication encoding of a payload.
+func dssePAE(payloadType string, payload []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("DSSEv1 ")
+	b.WriteString(strconv.Itoa(len(payloadType)))
+	b.WriteString(" ")
+	b.WriteString(payloadType)
+	b.WriteString(" ")
+	b.WriteString(strconv.Itoa(len(payload)))
+	b.WriteString(" ")
+	b.Write(payload)
+	return b.Bytes()
+}
+
+// keyID identifies a public key by the sha256 of its DER encoding.
+func keyID(pub ed25519.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// readPrivateKey reads a PKCS#8 PEM ed25519 private key, as written by
+// `openssl genpkey -algorithm ed25519`.
+func readPrivateKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key %s: %v", path, err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ed25519 private key", path)
+	}
+	return edKey, nil
+}
+
+// readPublicKey reads a PKIX PEM ed25519 public key.
+func readPublicKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing public key %s: %v", path, err)
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ed25519 public key", path)
+	}
+	return edKey, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading key: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s does not contain a PEM block", path)
+	}
+	return block, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignedReportRoundTrip(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	record := newAuditRecord("enclave.example.com")
+	record.Repo = "tinfoilsh/example"
+	record.Status = statusOK
+
+	payload, err := json.Marshal(record)
+	require.NoError(t, err)
+	env := signEnvelope(reportPayloadType, payload, priv)
+	data, err := json.Marshal(env)
+	require.NoError(t, err)
+
+	got, err := verifyReport(data, pub)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(payload), string(got))
+
+	otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, err = verifyReport(data, otherPub)
+	assert.Error(t, err)
+
+	tampered := *env
+	tampered.Payload = base64.StdEncoding.EncodeToString([]byte(`{"enclave":"enclave.example.com","status":"fail"}`))
+	data, err = json.Marshal(tampered)
+	require.NoError(t, err)
+	_, err = verifyReport(data, pub)
+	assert.Error(t, err)
+
+	data, err = json.Marshal(signEnvelope("application/octet-stream", payload, priv))
+	require.NoError(t, err)
+	_, err = verifyReport(data, pub)
+	assert.ErrorContains(t, err, "unexpected payload type")
+}
+
+func TestReadKeys(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	dir := t.TempDir()
+
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+	privPath := filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0600))
+
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	require.NoError(t, err)
+	pubPath := filepath.Join(dir, "key.pub")
+	require.NoError(t, os.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0644))
+
+	gotPriv, err := readPrivateKey(privPath)
+	require.NoError(t, err)
+	assert.Equal(t, priv, gotPriv)
+
+	gotPub, err := readPublicKey(pubPath)
+	require.NoError(t, err)
+	assert.Equal(t, pub, gotPub)
+
+	_, err = readPublicKey(privPath)
+	assert.Error(t, err)
+}
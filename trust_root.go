@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sigstore/sigstore-go/pkg/tuf"
+
+	"github.com/tinfoilsh/tinfoil-go/verifier/sigstore"
+)
+
+// trustRootPath backs the --trust-root flag.
+var trustRootPath string
+
+const (
+	trustRootUsage = "Sigstore trusted_root.json to verify against instead of fetching it"
+
+	// trustRootSourceDefault is the public-good Sigstore TUF repository
+	// that tinfoil-go fetches the trust root from.
+	trustRootSourceDefault = "sigstore-public-good"
+	trustRootSourceFile    = "file:"
+	trustRootSourceTUF     = "tuf:"
+)
+
+// trustRootInfo records where the Sigstore trust root came from and its
+// sha256, so a verification can be reproduced with the same trust root.
+type trustRootInfo struct {
+	Source string `json:"source"`
+	SHA256 string `json:"sha256"`
+}
+
+// trustRootSource describes where fetchTrustRoot will get the trust root:
+// the --trust-root file, the TUF mirror from the config, or the default.
+func trustRootSource() string {
+	if trustRootPath != "" {
+		return trustRootSourceFile + trustRootPath
+	}
+	if cfg, _, err := loadConfig(); err == nil && cfg.TUFMirror != "" {
+		return trustRootSourceTUF + cfg.TUFMirror
+	}
+	return trustRootSourceDefault
+}
+
+// fetchTrustRoot returns the Sigstore trust root from the source given by
+// trustRootSource. Fetched trust roots are cached for a day.
+func fetchTrustRoot() ([]byte, error) {
+	if trustRootPath != "" {
+		data, err := os.ReadFile(trustRootPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading trust root: %v", err)
+		}
+		return data, nil
+	}
+	return cachedFetch(cacheKindTrustRoot, trustRootSource(), cacheTTLTrustRoot, 0, lookupTrustRoot)
+}
+
+func lookupTrustRoot() ([]byte, error) {
+	cfg, _, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.TUFMirror != "" {
+		return fetchTUFTrustRoot(cfg.TUFMirror, cfg.TUFRoot)
+	}
+	return sigstore.FetchTrustRoot()
+}
+
+// fetchTUFTrustRoot fetches trusted_root.json from a TUF repository. rootPath
+// is the initial root.json of the repository; without it the embedded
+// public-good root is used, which only works for mirrors of that instance.
+func fetchTUFTrustRoot(mirror, rootPath string) ([]byte, error) {
+	opts := tuf.DefaultOptions().WithRepositoryBaseURL(mirror)
+	if rootPath != "" {
+		root, err := os.ReadFile(rootPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading TUF root: %v", err)
+		}
+		opts = opts.WithRoot(root)
+	}
+	client, err := tuf.New(opts)
+	if err != nil {
+		return nil, fmt.Errorf("initializing TUF client for %s: %v", mirror, err)
+	}
+	return client.GetTarget("trusted_root.json")
+}
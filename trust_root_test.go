@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrustRootSource(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "config.json")
+	t.Setenv(envConfigPath, cfgPath)
+	t.Setenv(envTUFMirror, "")
+	defer func(p string) { trustRootPath = p }(trustRootPath)
+
+	trustRootPath = ""
+	assert.Equal(t, trustRootSourceDefault, trustRootSource())
+
+	require.NoError(t, os.WriteFile(cfgPath, []byte(`{"tuf_mirror":"https://tuf.example.com"}`), 0o600))
+	assert.Equal(t, "tuf:https://tuf.example.com", trustRootSource())
+
+	t.Setenv(envTUFMirror, "https://other.example.com")
+	assert.Equal(t, "tuf:https://other.example.com", trustRootSource())
+
+	rootFile := filepath.Join(dir, "trusted_root.json")
+	require.NoError(t, os.WriteFile(rootFile, []byte(`{"mediaType":"x"}`), 0o600))
+	trustRootPath = rootFile
+	assert.Equal(t, "file:"+rootFile, trustRootSource())
+	data, err := fetchTrustRoot()
+	require.NoError(t, err)
+	assert.Equal(t, `{"mediaType":"x"}`, string(data))
+}
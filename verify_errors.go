@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Values of auditRecord.Status.
+const (
+	statusOK          = "ok"           // enclave matches the expected release
+	statusEnclaveOnly = "enclave_only" // enclave attestation is valid; no repo to compare against
+	statusFail        = "fail"         // verification completed and the enclave did not match
+	statusError       = "error"        // verification could not be completed
+)
+
+// Values of auditRecord.Reason. Reasons that describe a completed
+// verification that did not pass put the record in statusFail; the rest
+// mean the verification could not be completed and use statusError.
+const (
+	reasonMeasurementMismatch = "measurement_mismatch"
+	reasonKeyMismatch         = "key_mismatch"
+	reasonAttestationInvalid  = "attestation_invalid"
+	reasonPolicyViolation     = "policy_violation"
+	reasonKnownEnclaveChanged = "known_enclave_changed"
+	reasonSigstoreError       = "sigstore_error"
+	reasonFetchError          = "fetch_error"
+	reasonEvidenceError       = "evidence_error"
+)
+
+// Process exit codes for verification failures, so scripts can tell an
+// enclave that does not match apart from one that could not be checked.
+// Any other error exits with 1.
+const (
+	exitMeasurementMismatch = 10
+	exitKeyMismatch         = 11
+	exitAttestationInvalid  = 12
+	exitPolicyViolation     = 13
+	exitKnownEnclaveChanged = 14
+	exitSigstoreError       = 20
+	exitFetchError          = 30
+	exitEvidenceError       = 31
+)
+
+var reasonExitCodes = map[string]int{
+	reasonMeasurementMismatch: exitMeasurementMismatch,
+	reasonKeyMismatch:         exitKeyMismatch,
+	reasonAttestationInvalid:  exitAttestationInvalid,
+	reasonPolicyViolation:     exitPolicyViolation,
+	reasonKnownEnclaveChanged: exitKnownEnclaveChanged,
+	reasonSigstoreError:       exitSigstoreError,
+	reasonFetchError:          exitFetchError,
+	reasonEvidenceError:       exitEvidenceError,
+}
+
+// failReasons are the reasons that put a record in statusFail.
+var failReasons = map[string]bool{
+	reasonMeasurementMismatch: true,
+	reasonKeyMismatch:         true,
+	reasonAttestationInvalid:  true,
+	reasonPolicyViolation:     true,
+	reasonKnownEnclaveChanged: true,
+}
+
+// verificationError is returned when an enclave fails verification. Reason
+// is one of the reason* constants.
+type verificationError struct {
+	Reason string
+	Err    error
+}
+
+func (e *verificationError) Error() string { return e.Err.Error() }
+
+func (e *verificationError) Unwrap() error { return e.Err }
+
+func verifyErrorf(reason, format string, args ...any) error {
+	return &verificationError{Reason: reason, Err: fmt.Errorf(format, args...)}
+}
+
+// statusForReason returns the auditRecord.Status that goes with reason.
+func statusForReason(reason string) string {
+	if failReasons[reason] {
+		return statusFail
+	}
+	return statusError
+}
+
+// reasonOf returns the reason carried by err, or "" if err is not a
+// verification failure.
+func reasonOf(err error) string {
+	var ve *verificationError
+	if errors.As(err, &ve) {
+		return ve.Reason
+	}
+	return ""
+}
+
+// exitCode maps an error returned from a command to the process exit code.
+func exitCode(err error) int {
+	if code, ok := reasonExitCodes[reasonOf(err)]; ok {
+		return code
+	}
+	return 1
+}
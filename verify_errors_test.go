@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExitCode(t *testing.T) {
+	assert.Equal(t, 1, exitCode(errors.New("usage")))
+	assert.Equal(t, exitFetchError, exitCode(verifyErrorf(reasonFetchError, "network down")))
+	assert.Equal(t, exitMeasurementMismatch, exitCode(fmt.Errorf("wrapped: %w", verifyErrorf(reasonMeasurementMismatch, "x"))))
+}
+
+func TestAuditRecordFirstFailureWins(t *testing.T) {
+	rec := newAuditRecord("inference.tinfoil.sh")
+	assert.NoError(t, rec.err())
+
+	rec.fail(reasonKeyMismatch, "key")
+	rec.fail(reasonMeasurementMismatch, "measurement")
+
+	assert.Equal(t, statusFail, rec.Status)
+	assert.Equal(t, reasonKeyMismatch, rec.Reason)
+	assert.Equal(t, "key", rec.Error)
+	assert.Equal(t, exitKeyMismatch, exitCode(rec.err()))
+}
+
+func TestFailedRecordUsesErrorStatus(t *testing.T) {
+	rec := failedRecord("inference.tinfoil.sh", "tinfoilsh/repo", verifyErrorf(reasonFetchError, "dial tcp: timeout"))
+	assert.Equal(t, statusError, rec.Status)
+	assert.Equal(t, reasonFetchError, rec.Reason)
+	assert.Equal(t, "tinfoilsh/repo", rec.Repo)
+}
+
+func TestFailedRecordWithoutReasonIsAnError(t *testing.T) {
+	rec := failedRecord("inference.tinfoil.sh", "", errors.New("--tag and --digest require --repo"))
+	assert.Equal(t, statusError, rec.Status)
+	assert.Empty(t, rec.Reason)
+	assert.EqualError(t, rec.err(), "--tag and --digest require --repo")
+	assert.Equal(t, 1, exitCode(rec.err()))
+
+	err := routerPoolError([]*auditRecord{rec})
+	assert.EqualError(t, err, "1 of 1 routers failed verification: --tag and --digest require --repo")
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/tinfoilsh/tinfoil-go/verifier/attestation"
+)
+
+// Verification Summary Attestation output, see
+// https://slsa.dev/spec/v1.0/verification_summary.
+
+const (
+	inTotoStatementType = "https://in-toto.io/Statement/v1"
+	inTotoPayloadType   = "application/vnd.in-toto+json"
+	vsaPredicateType    = "https://slsa.dev/verification_summary/v1"
+	vsaVerifierID       = "https://github.com/tinfoilsh/tinfoil-cli"
+)
+
+type inTotoStatement struct {
+	Type          string              `json:"_type"`
+	Subject       []inTotoSubject     `json:"subject"`
+	PredicateType string              `json:"predicateType"`
+	Predicate     verificationSummary `json:"predicate"`
+}
+
+type inTotoSubject struct {
+	Name        string            `json:"name"`
+	Digest      map[string]string `json:"digest"`
+	Annotations map[string]any    `json:"annotations,omitempty"`
+}
+
+type resourceDescriptor struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+type verificationSummary struct {
+	Verifier struct {
+		ID      string            `json:"id"`
+		Version map[string]string `json:"version,omitempty"`
+	} `json:"verifier"`
+	TimeVerified       string               `json:"timeVerified"`
+	ResourceURI        string               `json:"resourceUri"`
+	Policy             resourceDescriptor   `json:"policy"`
+	InputAttestations  []resourceDescriptor `json:"inputAttestations,omitempty"`
+	VerificationResult string               `json:"verificationResult"`
+	VerifiedLevels     []string             `json:"verifiedLevels"`
+	SLSAVersion        string               `json:"slsaVersion,omitempty"`
+}
+
+// buildVSA summarizes record as an in-toto statement with a VSA predicate.
+// The subject is the enclave and the release digest it was compared to, or
+// its attested TLS key when no release was checked, or the hash of its name
+// when verification stopped before either was known; the Sigstore bundle is
+// listed as the input attestation. Only a full verification against a
+// release or policy passes: an enclave_only result is reported as FAILED.
+func buildVSA(record *auditRecord) (*inTotoStatement, error) {
+	subject := inTotoSubject{
+		Name: record.Enclave,
+		Annotations: map[string]any{
+			"status": record.Status,
+		},
+	}
+	switch {
+	case record.Digest != "":
+		subject.Digest = map[string]string{"sha256": record.Digest}
+	case record.Keys.Enclave != "":
+		subject.Digest = map[string]string{"sha256": record.Keys.Enclave}
+		subject.Annotations["digest_of"] = "tls_public_key"
+	case record.Status != statusOK:
+		// A verification that stopped before the enclave was reached has
+		// neither; the FAILED verdict is still about this enclave.
+		subject.Digest = map[string]string{"sha256": sha256Hex([]byte(record.Enclave))}
+		subject.Annotations["digest_of"] = "enclave_name"
+	default:
+		return nil, fmt.Errorf("cannot build a VSA for %s: no release digest or attested key to use as the subject digest", record.Enclave)
+	}
+	if record.Repo != "" {
+		subject.Annotations["repo"] = record.Repo
+	}
+	if record.Tag != "" {
+		subject.Annotations["tag"] = record.Tag
+	}
+	if m := vsaMeasurement(record); m != nil {
+		subject.Annotations["measurement"] = m
+	}
+	if record.Policy != nil && record.Policy.Entry != "" {
+		subject.Annotations["policy_entry"] = record.Policy.Entry
+	}
+	if record.Reason != "" {
+		subject.Annotations["reason"] = record.Reason
+	}
+
+	var summary verificationSummary
+	summary.Verifier.ID = vsaVerifierID
+	if v := cliVersion(); v != "" {
+		summary.Verifier.Version = map[string]string{"tinfoil-cli": v}
+	}
+	summary.TimeVerified = record.Timestamp
+	summary.ResourceURI = "https://" + record.Enclave
+	summary.Policy = vsaPolicy(record)
+	if record.BundleDigest != "" {
+		summary.InputAttestations = []resourceDescriptor{{
+			URI:    fmt.Sprintf("%s/repos/%s/attestations/sha256:%s", currentGitHub().APIURL, record.Repo, record.Digest),
+			Digest: map[string]string{"sha256": record.BundleDigest},
+		}}
+	}
+	summary.VerificationResult = "FAILED"
+	if record.Status == statusOK {
+		summary.VerificationResult = "PASSED"
+	}
+	summary.VerifiedLevels = []string{}
+	summary.SLSAVersion = "1.0"
+
+	return &inTotoStatement{
+		Type:          inTotoStatementType,
+		Subject:       []inTotoSubject{subject},
+		PredicateType: vsaPredicateType,
+		Predicate:     summary,
+	}, nil
+}
+
+// vsaPolicy describes what the enclave was checked against: a policy file,
+// the release named by the tag or its digest, or only the hardware
+// attestation.
+func vsaPolicy(record *auditRecord) resourceDescriptor {
+	switch {
+	case record.Policy != nil:
+		return resourceDescriptor{
+			URI:    record.Policy.File,
+			Digest: map[string]string{"sha256": record.Policy.SHA256},
+		}
+	case record.Repo == "":
+		return resourceDescriptor{URI: vsaVerifierID + "#enclave-attestation"}
+	case record.Tag != "":
+		return resourceDescriptor{URI: fmt.Sprintf("%s/%s/releases/tag/%s", currentGitHub().URL, record.Repo, record.Tag)}
+	default:
+		return resourceDescriptor{
+			URI:    fmt.Sprintf("%s/%s/releases", currentGitHub().URL, record.Repo),
+			Digest: map[string]string{"sha256": record.Digest},
+		}
+	}
+}
+
+// vsaMeasurement returns the measurement the verdict is about: the enclave's
+// attested measurement, falling back to the one from the Sigstore bundle.
+func vsaMeasurement(record *auditRecord) *attestation.Measurement {
+	if record.Measurements.Enclave != nil {
+		return record.Measurements.Enclave
+	}
+	if len(record.Measurements.Sigstore.Registers) > 0 {
+		return &record.Measurements.Sigstore
+	}
+	return nil
+}
+
+// cliVersion returns the module version the binary was built from.
+func cliVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	return info.Main.Version
+}
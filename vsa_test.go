@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tinfoilsh/tinfoil-go/verifier/attestation"
+)
+
+func TestBuildVSA(t *testing.T) {
+	useGitHub(t, githubSettings{APIURL: defaultGitHubAPIURL, URL: defaultGitHubURL})
+	record := newAuditRecord("enclave.example.com")
+	record.Repo = "tinfoilsh/example"
+	record.Tag = "v1.2.3"
+	record.Digest = "abc123"
+	record.BundleDigest = "def456"
+	record.Status = statusOK
+	record.Measurements.Enclave = &attestation.Measurement{Type: "t", Registers: []string{"r1"}}
+
+	vsa, err := buildVSA(record)
+	require.NoError(t, err)
+	assert.Equal(t, vsaPredicateType, vsa.PredicateType)
+	assert.Equal(t, "enclave.example.com", vsa.Subject[0].Name)
+	assert.Equal(t, map[string]string{"sha256": "abc123"}, vsa.Subject[0].Digest)
+	assert.Equal(t, record.Measurements.Enclave, vsa.Subject[0].Annotations["measurement"])
+	assert.Equal(t, "tinfoilsh/example", vsa.Subject[0].Annotations["repo"])
+	assert.Equal(t, "PASSED", vsa.Predicate.VerificationResult)
+	assert.Equal(t, "https://github.com/tinfoilsh/example/releases/tag/v1.2.3", vsa.Predicate.Policy.URI)
+	assert.Equal(t, []resourceDescriptor{{
+		URI:    "https://api.github.com/repos/tinfoilsh/example/attestations/sha256:abc123",
+		Digest: map[string]string{"sha256": "def456"},
+	}}, vsa.Predicate.InputAttestations)
+
+	record.Status = statusFail
+	record.Reason = reasonMeasurementMismatch
+	vsa, err = buildVSA(record)
+	require.NoError(t, err)
+	assert.Equal(t, "FAILED", vsa.Predicate.VerificationResult)
+}
+
+func TestBuildVSAEnclaveOnly(t *testing.T) {
+	record := newAuditRecord("enclave.example.com")
+	record.Status = statusEnclaveOnly
+	record.Keys.Enclave = "0a0b"
+
+	vsa, err := buildVSA(record)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"sha256": "0a0b"}, vsa.Subject[0].Digest)
+	assert.Equal(t, "tls_public_key", vsa.Subject[0].Annotations["digest_of"])
+	assert.Equal(t, "FAILED", vsa.Predicate.VerificationResult)
+
+	record.Status = statusOK
+	record.Keys.Enclave = ""
+	_, err = buildVSA(record)
+	assert.ErrorContains(t, err, "no release digest or attested key")
+}
+
+func TestBuildVSAFetchError(t *testing.T) {
+	record := failedRecord("enclave.example.com", "", verifyErrorf(reasonFetchError, "dial tcp: timeout"))
+
+	vsa, err := buildVSA(record)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"sha256": sha256Hex([]byte("enclave.example.com"))}, vsa.Subject[0].Digest)
+	assert.Equal(t, "enclave_name", vsa.Subject[0].Annotations["digest_of"])
+	assert.Equal(t, reasonFetchError, vsa.Subject[0].Annotations["reason"])
+	assert.Equal(t, "FAILED", vsa.Predicate.VerificationResult)
+}